@@ -3,6 +3,9 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"dcloud/internal/manager"
 )
@@ -14,7 +17,51 @@ func main() {
 		log.Fatal("MANAGER_ADDR and MONGO_URL environment variables must be set")
 	}
 
-	m, err := manager.New(addr, mondodb)
+	var minDomains int
+	if value := os.Getenv("MIN_DOMAINS"); value != "" {
+		var err error
+		if minDomains, err = strconv.Atoi(value); err != nil || minDomains < 0 {
+			log.Fatalf("Invalid MIN_DOMAINS: %q", value)
+		}
+	}
+
+	var replicas int
+	if value := os.Getenv("REPLICAS"); value != "" {
+		var err error
+		if replicas, err = strconv.Atoi(value); err != nil || replicas < 1 {
+			log.Fatalf("Invalid REPLICAS: %q", value)
+		}
+	}
+
+	var storageTimeout time.Duration
+	if value := os.Getenv("STORAGE_TIMEOUT"); value != "" {
+		var err error
+		if storageTimeout, err = time.ParseDuration(value); err != nil || storageTimeout <= 0 {
+			log.Fatalf("Invalid STORAGE_TIMEOUT: %q", value)
+		}
+	}
+
+	m, err := manager.New(manager.Config{
+		Addr:           addr,
+		MetaURI:        mondodb,
+		APIKeysFile:    os.Getenv("AUTH_API_KEYS"),
+		HMACKeysFile:   os.Getenv("AUTH_HMAC_KEYS"),
+		JWKSFile:       os.Getenv("AUTH_JWKS"),
+		JWTIssuer:      os.Getenv("AUTH_JWT_ISSUER"),
+		JWTAudience:    os.Getenv("AUTH_JWT_AUDIENCE"),
+		ClusterSecret:  os.Getenv("CLUSTER_SECRET"),
+		PresignSecret:  os.Getenv("PRESIGN_SECRET"),
+		TLSCert:        os.Getenv("MANAGER_TLS_CERT"),
+		TLSKey:         os.Getenv("MANAGER_TLS_KEY"),
+		StorageCA:      os.Getenv("STORAGE_CA"),
+		KeyFile:        os.Getenv("SSE_KEYFILE"),
+		Placement:      os.Getenv("PLACEMENT"),
+		FailureDomain:  os.Getenv("FAILURE_DOMAIN"),
+		MinDomains:     minDomains,
+		Replicas:       replicas,
+		StorageTimeout: storageTimeout,
+		Admins:         strings.FieldsFunc(os.Getenv("AUTH_ADMINS"), func(r rune) bool { return r == ',' || r == ' ' }),
+	})
 	if err != nil {
 		log.Fatalf("Failed to create manager: %v", err)
 	}
@@ -4,6 +4,7 @@ import (
 	"dcloud/internal/storage"
 	"log"
 	"os"
+	"time"
 )
 
 func main() {
@@ -21,6 +22,23 @@ func main() {
 	if err != nil {
 		log.Fatalf("Storage %s create error: %v", addr, err)
 	}
+	s.APIKey = os.Getenv("REGISTER_API_KEY")
+	s.ClusterSecret = os.Getenv("CLUSTER_SECRET")
+	s.TLSCert = os.Getenv("STORAGE_TLS_CERT")
+	s.TLSKey = os.Getenv("STORAGE_TLS_KEY")
+	s.Host = os.Getenv("STORAGE_HOST")
+	s.ManagerCA = os.Getenv("MANAGER_CA")
+	s.Capacity = os.Getenv("STORAGE_LIMIT")
+	s.Headroom = os.Getenv("STORAGE_HEADROOM")
+	s.Topology = os.Getenv("STORAGE_TOPOLOGY")
+	s.Class = os.Getenv("STORAGE_CLASS")
+
+	if value := os.Getenv("STORAGE_HEARTBEAT"); value != "" {
+		// the manager fails a storage that stops reporting, so the heartbeats cannot be turned off
+		if s.Heartbeat, err = time.ParseDuration(value); err != nil || s.Heartbeat <= 0 {
+			log.Fatalf("Invalid STORAGE_HEARTBEAT: %q", value)
+		}
+	}
 
 	if err = s.Start(); err != nil {
 		log.Fatalf("Storage %s start error: %v", addr, err)
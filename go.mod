@@ -2,11 +2,13 @@ module dcloud
 
 go 1.23.4
 
-require go.mongodb.org/mongo-driver v1.17.1
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.17.11
+	go.mongodb.org/mongo-driver v1.17.1
+)
 
 require (
-	github.com/golang/snappy v0.0.4 // indirect
-	github.com/klauspost/compress v1.17.11 // indirect
 	github.com/montanaflynn/stats v0.7.1 // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.2 // indirect
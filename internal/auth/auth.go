@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+)
+
+var (
+	// ErrNoCredentials is returned by an authenticator when the request has no credentials of its kind.
+	ErrNoCredentials = errors.New("no credentials")
+
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated caller.
+type Principal struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+}
+
+// Authenticator authenticates requests.
+type Authenticator interface {
+	// Authenticate returns the principal of the request, ErrNoCredentials if the request
+	// has no credentials for this authenticator, or another error if they are invalid.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries the authenticators in order; the first one that finds its credentials decides.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		principal, err := a.Authenticate(r)
+		if err != ErrNoCredentials {
+			return principal, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// Key ids of the requests signed with the cluster secret.
+const (
+	ManagerPrincipal = "manager"
+	StoragePrincipal = "storage"
+)
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context carrying the principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of the context, nil if the request is not authenticated.
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Middleware rejects the requests that the authenticator does not accept
+// and passes the principal of the others in the request context.
+func Middleware(a Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			if err != ErrNoCredentials {
+				log.Printf("Authentication failed for %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dcloud"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HMACScheme is the Authorization scheme of HMAC-signed requests:
+	//
+	//	Authorization: DCLOUD-HMAC-SHA256 Credential=<key id>, Signature=<hex>
+	HMACScheme = "DCLOUD-HMAC-SHA256"
+
+	// DateHeader carries the signing time of an HMAC-signed request in RFC 3339 format.
+	DateHeader = "X-Date"
+
+	// NonceHeader carries a random value unique to an HMAC-signed request, see RejectReplays.
+	NonceHeader = "X-Nonce"
+
+	// ContentHashHeader optionally carries the hex SHA-256 of the body, covered by the signature.
+	// Reading the body of a request fails at its end if the body does not match it.
+	ContentHashHeader = "X-Content-SHA256"
+
+	// UnsignedPayload is the content hash of requests whose body is not signed.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// maxSkew is the maximum difference between the signing time and the time of the server.
+	maxSkew = 15 * time.Minute
+)
+
+// signedHeaders are the headers besides the date and the content hash covered by the signature,
+// empty if the request has none: the cluster requests name the segment and its size with them.
+var signedHeaders = []string{NonceHeader, "X-Filename", "X-Size"}
+
+// HMACKeys authenticates requests signed with shared secrets.
+type HMACKeys struct {
+	secrets map[string][]byte
+
+	// nonces are the nonces of the accepted requests by key id and nonce until their signatures
+	// expire, purged at most every minute; nil unless replays are rejected
+	noncesLock sync.Mutex
+	nonces     map[string]time.Time
+	purged     time.Time
+}
+
+// LoadHMACKeys loads the secrets from a JSON file mapping each key id to its secret:
+//
+//	{"ci": "c2VjcmV0...", "alice": "..."}
+//
+// The key id is the principal of the requests signed with it.
+func LoadHMACKeys(path string) (*HMACKeys, error) {
+	keys := make(map[string]string)
+	if err := readJSON(path, &keys); err != nil {
+		return nil, err
+	}
+
+	return NewHMACKeys(keys), nil
+}
+
+// NewHMACKeys creates the authenticator of the secrets by key id.
+func NewHMACKeys(keys map[string]string) *HMACKeys {
+	h := &HMACKeys{secrets: make(map[string][]byte, len(keys))}
+	for id, secret := range keys {
+		h.secrets[id] = []byte(secret)
+	}
+	return h
+}
+
+// RejectReplays requires a nonce in every request and refuses a request whose nonce was accepted
+// already while its signature is valid, so that a captured request cannot be sent again.
+func (h *HMACKeys) RejectReplays() *HMACKeys {
+	h.nonces = make(map[string]time.Time)
+	return h
+}
+
+// Authenticate implements Authenticator.
+func (h *HMACKeys) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != HMACScheme {
+		return nil, ErrNoCredentials
+	}
+
+	var id, signature string
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch key {
+		case "Credential":
+			id = value
+		case "Signature":
+			signature = value
+		}
+	}
+
+	secret, found := h.secrets[id]
+	if !found {
+		return nil, fmt.Errorf("unknown key id '%s': %w", id, ErrInvalidCredentials)
+	}
+
+	date, err := time.Parse(time.RFC3339, r.Header.Get(DateHeader))
+	if err != nil {
+		return nil, fmt.Errorf("%s header: %w", DateHeader, ErrInvalidCredentials)
+	}
+	if skew := time.Since(date); skew > maxSkew || skew < -maxSkew {
+		return nil, fmt.Errorf("request signed at %v: %w", date, ErrInvalidCredentials)
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, Signature(secret, r)) {
+		return nil, fmt.Errorf("signature mismatch for key id '%s': %w", id, ErrInvalidCredentials)
+	}
+
+	if h.nonces != nil {
+		if err := h.claimNonce(id, r.Header.Get(NonceHeader), date); err != nil {
+			return nil, err
+		}
+	}
+
+	if contentHash := r.Header.Get(ContentHashHeader); contentHash != "" && contentHash != UnsignedPayload && r.Body != nil {
+		r.Body = &hashVerifier{ReadCloser: r.Body, hash: sha256.New(), expected: strings.ToLower(contentHash)}
+	}
+	return &Principal{ID: id, Method: "hmac"}, nil
+}
+
+// claimNonce records the nonce of a request of the key id signed at date.
+// It fails if the nonce is missing or was recorded already.
+func (h *HMACKeys) claimNonce(id, nonce string, date time.Time) error {
+	if nonce == "" {
+		return fmt.Errorf("%s header: %w", NonceHeader, ErrInvalidCredentials)
+	}
+
+	h.noncesLock.Lock()
+	defer h.noncesLock.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.purged) > time.Minute {
+		for key, expires := range h.nonces {
+			if now.After(expires) {
+				delete(h.nonces, key)
+			}
+		}
+		h.purged = now
+	}
+
+	key := id + "\n" + nonce
+	if _, seen := h.nonces[key]; seen {
+		return fmt.Errorf("replayed request of key id '%s': %w", id, ErrInvalidCredentials)
+	}
+	h.nonces[key] = date.Add(maxSkew)
+	return nil
+}
+
+// Sign signs the request with the secret of the key id, setting the date and nonce headers
+// if they are missing and the Authorization header.
+func Sign(r *http.Request, id string, secret []byte) {
+	if r.Header.Get(DateHeader) == "" {
+		r.Header.Set(DateHeader, time.Now().UTC().Format(time.RFC3339))
+	}
+	if r.Header.Get(NonceHeader) == "" {
+		var nonce [16]byte
+		rand.Read(nonce[:])
+		r.Header.Set(NonceHeader, hex.EncodeToString(nonce[:]))
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, Signature=%s",
+		HMACScheme, id, hex.EncodeToString(Signature(secret, r))))
+}
+
+// Signature returns the HMAC-SHA256 of the string to sign of the request:
+//
+//	METHOD \n host \n path \n sorted query \n date \n nonce \n X-Filename \n X-Size \n content hash
+//
+// The host is the one the request is sent to. The content hash is UNSIGNED-PAYLOAD
+// when the request has no content hash header.
+func Signature(secret []byte, r *http.Request) []byte {
+	contentHash := r.Header.Get(ContentHashHeader)
+	if contentHash == "" {
+		contentHash = UnsignedPayload
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n",
+		r.Method, host, r.URL.EscapedPath(), r.URL.Query().Encode(), r.Header.Get(DateHeader))
+	for _, header := range signedHeaders {
+		fmt.Fprintf(mac, "%s\n", r.Header.Get(header))
+	}
+	fmt.Fprint(mac, contentHash)
+	return mac.Sum(nil)
+}
+
+// hashVerifier fails the read of the body at its end if its hash differs from the signed one.
+type hashVerifier struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (v *hashVerifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.expected {
+		return n, errors.New("content hash mismatch")
+	}
+	return n, err
+}
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWT authenticates requests with bearer tokens signed by a key of a local JWKS file.
+// The subject of the token is the principal.
+type JWT struct {
+	sync.Mutex
+	path     string
+	modTime  time.Time
+	keys     map[string]crypto.PublicKey
+	issuer   string
+	audience string
+}
+
+// jwk is a JSON web key; only the public key parameters are read.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type claims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+}
+
+var errAlgorithm = errors.New("unsupported algorithm")
+
+// LoadJWKS loads the RSA, EC and Ed25519 public keys of the JWKS file.
+// The file is read again when a token refers to an unknown key id and the file changed,
+// so keys can be rotated without a restart. If issuer or audience are set,
+// the tokens must have them in their iss and aud claims.
+func LoadJWKS(path, issuer, audience string) (*JWT, error) {
+	j := &JWT{path: path, issuer: issuer, audience: audience}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// load reads the key set if the file changed since it was last read.
+func (j *JWT) load() error {
+	stat, err := os.Stat(j.path)
+	if err != nil {
+		return err
+	}
+	if stat.ModTime().Equal(j.modTime) {
+		return nil
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = readJSON(j.path, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		public, err := key.public()
+		if err != nil {
+			return fmt.Errorf("key '%s': %w", key.Kid, err)
+		}
+		keys[key.Kid] = public
+	}
+
+	j.keys = keys
+	j.modTime = stat.ModTime()
+	return nil
+}
+
+// key returns the public key with the key id, the only key of the set if kid is empty.
+func (j *JWT) key(kid string) (crypto.PublicKey, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+
+	if key, found := j.keys[kid]; found {
+		return key, nil
+	}
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	if key, found := j.keys[kid]; found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id '%s'", kid)
+}
+
+// Authenticate implements Authenticator.
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	c, err := j.verify(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("bearer token: %v: %w", err, ErrInvalidCredentials)
+	}
+	return &Principal{ID: c.Subject, Method: "jwt"}, nil
+}
+
+// verify checks the signature and the claims of the token.
+func (j *JWT) verify(token string) (*claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := j.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	c := new(claims)
+	if err = decodeSegment(parts[1], c); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	switch {
+	case c.Subject == "":
+		return nil, errors.New("no subject")
+	case c.ExpiresAt == nil || *c.ExpiresAt <= now:
+		return nil, errors.New("expired")
+	case c.NotBefore != nil && *c.NotBefore > now:
+		return nil, errors.New("not valid yet")
+	case j.issuer != "" && c.Issuer != j.issuer:
+		return nil, fmt.Errorf("issuer '%s'", c.Issuer)
+	case j.audience != "" && !c.hasAudience(j.audience):
+		return nil, errors.New("audience mismatch")
+	}
+	return c, nil
+}
+
+// hasAudience reports whether the aud claim, a string or an array of strings, contains the audience.
+func (c *claims) hasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == audience
+	}
+
+	var list []string
+	json.Unmarshal(c.Audience, &list)
+	for _, aud := range list {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature verifies the signature of the signed content with the algorithm of the token header.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[max(len(alg)-3, 0):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	valid := false
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case hash != 0 && strings.HasPrefix(alg, "RS"):
+			valid = rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		case hash != 0 && strings.HasPrefix(alg, "PS"):
+			valid = rsa.VerifyPSS(key, hash, digest, signature, nil) == nil
+		default:
+			return fmt.Errorf("%w '%s' for an RSA key", errAlgorithm, alg)
+		}
+
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if hash == 0 || !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("%w '%s' for an EC key", errAlgorithm, alg)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		valid = ecdsa.Verify(key, digest, r, s)
+
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("%w '%s' for an Ed25519 key", errAlgorithm, alg)
+		}
+		valid = ed25519.Verify(key, signed, signature)
+	}
+
+	if !valid {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// public returns the public key of the JSON web key.
+func (k *jwk) public() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters of the segment URLs signed for direct transfers between clients and storages.
+const (
+	TokenParam        = "X-Dcloud-Token"
+	TokenExpiresParam = "X-Dcloud-Expires"
+	TokenSizeParam    = "X-Dcloud-Size"
+)
+
+// Tokens authenticates the requests of segment URLs signed with the cluster secret by SignURL.
+type Tokens struct {
+	secret []byte
+}
+
+// NewTokens creates the authenticator of the URLs signed with the secret.
+func NewTokens(secret string) *Tokens {
+	return &Tokens{secret: []byte(secret)}
+}
+
+// SignURL returns the URL with a token that allows the method on it until expires.
+// A size greater than zero is the exact Content-Length the request must have.
+// The token is only valid on the host of the URL.
+func SignURL(secret []byte, method, rawURL string, size int64, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set(TokenExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	if size > 0 {
+		query.Set(TokenSizeParam, strconv.FormatInt(size, 10))
+	}
+	query.Set(TokenParam, tokenSignature(secret, method, u.Host, u.EscapedPath(), query))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Authenticate implements Authenticator.
+func (t *Tokens) Authenticate(r *http.Request) (*Principal, error) {
+	query := r.URL.Query()
+	token := query.Get(TokenParam)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	expected, err := hex.DecodeString(token)
+	actual, _ := hex.DecodeString(tokenSignature(t.secret, r.Method, r.Host, r.URL.EscapedPath(), query))
+	if err != nil || !hmac.Equal(expected, actual) {
+		return nil, fmt.Errorf("token signature: %w", ErrInvalidCredentials)
+	}
+
+	expires, _ := strconv.ParseInt(query.Get(TokenExpiresParam), 10, 64)
+	if time.Now().Unix() >= expires {
+		return nil, fmt.Errorf("token expired: %w", ErrInvalidCredentials)
+	}
+
+	if size := query.Get(TokenSizeParam); size != "" && strconv.FormatInt(r.ContentLength, 10) != size {
+		return nil, fmt.Errorf("content length %d instead of %s: %w", r.ContentLength, size, ErrInvalidCredentials)
+	}
+	return &Principal{ID: token, Method: "token"}, nil
+}
+
+// Receipt returns the receipt a storage gives for a segment uploaded with the token,
+// so the manager can trust the hash and the temporary name reported by the client.
+func Receipt(secret []byte, token, hash, tmp string, size int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "receipt\n%s\n%s\n%s\n%d", token, hash, tmp, size)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// tokenSignature signs the method, the host, the path and the query without the token.
+func tokenSignature(secret []byte, method, host, path string, query url.Values) string {
+	unsigned := url.Values{}
+	for key, values := range query {
+		if key != TokenParam {
+			unsigned[key] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "token\n%s\n%s\n%s\n%s", method, host, path, unsigned.Encode())
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,55 @@
+// Package compress compresses the content of the segments before they are stored.
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrUnsupported is returned for an unknown algorithm.
+var ErrUnsupported = errors.New("unsupported compression")
+
+// The supported algorithms. The empty algorithm stores the content as is.
+const (
+	Zstd   = "zstd"
+	Snappy = "snappy"
+)
+
+// Valid reports whether the algorithm is supported.
+func Valid(algorithm string) bool {
+	switch algorithm {
+	case "", Zstd, Snappy:
+		return true
+	}
+	return false
+}
+
+// NewWriter returns a writer compressing to w. Close flushes the compressed stream but does not close w.
+func NewWriter(algorithm string, w io.Writer) (io.WriteCloser, error) {
+	switch algorithm {
+	case Zstd:
+		return zstd.NewWriter(w)
+	case Snappy:
+		return snappy.NewBufferedWriter(w), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnsupported, algorithm)
+}
+
+// NewReader returns a reader decompressing r.
+func NewReader(algorithm string, r io.Reader) (io.ReadCloser, error) {
+	switch algorithm {
+	case Zstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case Snappy:
+		return io.NopCloser(snappy.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnsupported, algorithm)
+}
@@ -0,0 +1,213 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"dcloud/internal/file"
+)
+
+// Algorithm is the encryption algorithm of the content.
+const Algorithm = "AES256-GCM"
+
+// Keyring holds the master keys that wrap the data keys of the content.
+// New data keys are wrapped with the current master key; the older ones stay
+// in the keyfile to unwrap the data keys until these are wrapped again.
+type Keyring struct {
+	sync.RWMutex
+	path    string
+	current string
+	keys    map[string][]byte
+}
+
+// keyfile is the format of the keyfile:
+//
+//	{"current": "2026-10", "keys": {"2026-04": "<base64 32 bytes>", "2026-10": "<base64 32 bytes>"}}
+type keyfile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadKeyring loads the master keys of the keyfile.
+func LoadKeyring(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload loads the keyfile again, e.g. after a new current key was added.
+func (k *Keyring) Reload() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+
+	var kf keyfile
+	if err = json.Unmarshal(data, &kf); err != nil {
+		return err
+	}
+
+	keys := make(map[string][]byte, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("master key '%s' must be 32 bytes in base64", id)
+		}
+		keys[id] = key
+	}
+
+	if _, found := keys[kf.Current]; !found {
+		return fmt.Errorf("current master key '%s' not found", kf.Current)
+	}
+
+	k.Lock()
+	k.current = kf.Current
+	k.keys = keys
+	k.Unlock()
+	return nil
+}
+
+// Current returns the id of the current master key.
+func (k *Keyring) Current() string {
+	k.RLock()
+	defer k.RUnlock()
+	return k.current
+}
+
+// NewDataKey generates a data key and returns it with its encryption description,
+// which holds the key wrapped with the current master key.
+func (k *Keyring) NewDataKey() ([]byte, *file.Encryption, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	enc, err := k.wrap(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, enc, nil
+}
+
+// Unwrap returns the data key of the encryption description.
+func (k *Keyring) Unwrap(enc *file.Encryption) ([]byte, error) {
+	k.RLock()
+	master, found := k.keys[enc.KeyID]
+	k.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("master key '%s' not found", enc.KeyID)
+	}
+
+	return unwrapKey(master, enc.WrappedKey, enc.KeyID)
+}
+
+// Rewrap returns the encryption description with the data key wrapped with the current master key.
+func (k *Keyring) Rewrap(enc *file.Encryption) (*file.Encryption, error) {
+	key, err := k.Unwrap(enc)
+	if err != nil {
+		return nil, err
+	}
+	return k.wrap(key)
+}
+
+// wrap encrypts the data key with the current master key, bound to the key id.
+func (k *Keyring) wrap(key []byte) (*file.Encryption, error) {
+	k.RLock()
+	id, master := k.current, k.keys[k.current]
+	k.RUnlock()
+
+	wrapped, err := wrapKey(master, key, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file.Encryption{
+		Algorithm:  Algorithm,
+		KeyID:      id,
+		WrappedKey: wrapped,
+	}, nil
+}
+
+// wrapKey encrypts the data key with the key encryption key under a random nonce, bound to the label.
+func wrapKey(kek, key []byte, label string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, []byte(label)), nil
+}
+
+// unwrapKey decrypts the data key wrapped by wrapKey.
+func unwrapKey(kek, wrapped []byte, label string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	nonce, wrapped := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, wrapped, []byte(label))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Fingerprint returns the base64 SHA-256 of a customer-provided key, the only trace of it that is stored.
+func Fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// NewCustomerDataKey generates a data key for content encrypted with a customer-provided key and returns it
+// with its encryption description, which holds the fingerprint of the customer key and the data key wrapped
+// with it. Every content gets its own data key, so the nonces of the frames are never reused under a key.
+func NewCustomerDataKey(customer []byte) ([]byte, *file.Encryption, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	fingerprint := Fingerprint(customer)
+	wrapped, err := wrapKey(customer, key, fingerprint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, &file.Encryption{
+		Algorithm:   Algorithm,
+		Fingerprint: fingerprint,
+		WrappedKey:  wrapped,
+	}, nil
+}
+
+// UnwrapCustomer returns the data key of content encrypted with the customer-provided key,
+// whose fingerprint was checked. Content without a wrapped key was encrypted with the customer key itself.
+func UnwrapCustomer(customer []byte, enc *file.Encryption) ([]byte, error) {
+	if len(enc.WrappedKey) == 0 {
+		return customer, nil
+	}
+	return unwrapKey(customer, enc.WrappedKey, enc.Fingerprint)
+}
@@ -0,0 +1,120 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// FrameSize is the size of the plaintext frames a segment is encrypted in.
+// Each frame is sealed on its own, so a segment is streamed without buffering it.
+const FrameSize = 64 << 10
+
+// Overhead is the size of the authentication tag of a frame.
+const Overhead = 16
+
+// EncryptedSize returns the size of a segment of n plaintext bytes once encrypted.
+func EncryptedSize(n int64) int64 {
+	frames := (n + FrameSize - 1) / FrameSize
+	if frames == 0 {
+		frames = 1
+	}
+	return n + frames*Overhead
+}
+
+// stream seals or opens the frames of a segment. The nonce of a frame is the segment index
+// and the frame counter, unique for the data key of the content; the last frame is marked
+// in the additional data, so a truncated segment does not decrypt.
+type stream struct {
+	aead    cipher.AEAD
+	src     *bufio.Reader
+	segment uint32
+	frame   uint64
+	in      []byte
+	buf     []byte
+	out     []byte
+	done    bool
+	seal    bool
+}
+
+// NewEncrypter returns a reader of the encrypted segment with the index read from r.
+func NewEncrypter(key []byte, segment int, r io.Reader) (io.Reader, error) {
+	return newStream(key, segment, r, true)
+}
+
+// NewDecrypter returns a reader of the plaintext of the encrypted segment with the index read from r.
+func NewDecrypter(key []byte, segment int, r io.Reader) (io.Reader, error) {
+	return newStream(key, segment, r, false)
+}
+
+func newStream(key []byte, segment int, r io.Reader, seal bool) (*stream, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	size := FrameSize
+	if !seal {
+		size += Overhead
+	}
+
+	return &stream{
+		aead:    aead,
+		src:     bufio.NewReaderSize(r, size),
+		segment: uint32(segment),
+		in:      make([]byte, size),
+		seal:    seal,
+	}, nil
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// next seals or opens the next frame.
+func (s *stream) next() error {
+	n, err := io.ReadFull(s.src, s.in)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		s.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err = s.src.Peek(1); err == io.EOF {
+			s.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	var nonce [12]byte
+	binary.BigEndian.PutUint32(nonce[:4], s.segment)
+	binary.BigEndian.PutUint64(nonce[4:], s.frame)
+	s.frame++
+
+	final := []byte{0}
+	if s.done {
+		final[0] = 1
+	}
+
+	if s.seal {
+		s.buf = s.aead.Seal(s.buf[:0], nonce[:], s.in[:n], final)
+	} else if s.buf, err = s.aead.Open(s.buf[:0], nonce[:], s.in[:n], final); err != nil {
+		return errors.New("segment decryption failed")
+	}
+	s.out = s.buf
+	return nil
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"dcloud/internal/file"
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrExists       = errors.New("already exists")
+	ErrPrecondition = errors.New("precondition failed")
+	ErrVersions     = errors.New("has other versions")
+)
+
+// DB is the metadata store of the manager.
+//
+// Every stored name is a version in the files collection pointing to the
+// content metadata by hash. The metadata counts the versions referencing it;
+// the content is released once the last reference is deleted.
+type DB interface {
+	// Store stores a new version of the file and its metadata atomically.
+	// A file info without metadata links the name to the existing content with the same hash.
+	// If the content is already stored, the existing metadata is kept.
+	// Store sets the version and creation time of the file info and the segment URLs
+	// of the content it is linked to, and returns the metadata of the content released by an overwrite.
+	Store(fileInfo *file.Info, opts StoreOptions) (released []*file.Meta, err error)
+
+	// Load loads the latest version of the file by name or, if the name is unknown, by hash.
+	Load(name string, hash ...string) (*file.Info, error)
+
+	// LoadVersion loads the given version of the file.
+	LoadVersion(name, version string) (*file.Info, error)
+
+	// LoadMeta loads the content metadata by hash.
+	LoadMeta(hash string) (*file.Meta, error)
+
+	// Names lists the names with a version referencing the content with the hash.
+	Names(hash string) ([]string, error)
+
+	// List lists the files matching the options, sorted by name.
+	List(opts ListOptions) ([]*file.Info, error)
+
+	// Delete deletes the given version of the file, or all its versions if version is empty.
+	// With created given, the version is only deleted if it was stored at that time,
+	// so a version that was replaced in the meantime is kept.
+	// It returns the metadata of the content that is no longer referenced.
+	Delete(name, version string, created ...time.Time) (released []*file.Meta, err error)
+
+	// Move stores dst as a new version linked to the content of src and deletes
+	// the src version, stored at src.Created, in one transaction. It fails with ErrVersions
+	// if the name of src has other versions.
+	// It returns the metadata of the content released by an overwrite of dst.
+	Move(src, dst *file.Info, opts StoreOptions) (released []*file.Meta, err error)
+
+	// Expiring lists up to limit versions whose expiry time is not after now.
+	Expiring(now time.Time, limit int) ([]*file.Info, error)
+
+	// Older lists up to limit versions with the name prefix stored before the given time.
+	Older(prefix string, before time.Time, limit int) ([]*file.Info, error)
+
+	// StaleEncryption lists up to limit content metadata whose data key is wrapped with another
+	// master key than the current one, in the order of their hashes from the one after the given hash.
+	StaleEncryption(current, after string, limit int) ([]*file.Meta, error)
+
+	// SetEncryption replaces the encryption description of the content, after its data key was wrapped again.
+	SetEncryption(hash string, enc *file.Encryption) error
+
+	// SegmentInUse reports whether any content metadata refers to the segment URL, as a segment or a replica.
+	SegmentInUse(url string) (bool, error)
+
+	// Transitions lists up to limit content metadata the transition rule moves by now:
+	// the content of versions with its prefix stored before its days, see file.Rule.Moves.
+	Transitions(rule *file.Rule, now time.Time, limit int) ([]*file.Meta, error)
+
+	// MoveSegments replaces the segment and replica URLs of the content, if they are still the ones
+	// of old, after its segments were copied to the storages of the class, and resets its reads.
+	// It returns ErrNotFound if the content was changed or released meanwhile.
+	MoveSegments(hash string, old *file.Meta, segments, replicas []string, class string) error
+
+	// Touch counts reads of the content, the last one at the given time.
+	Touch(hash string, reads int64, at time.Time) error
+
+	// SegmentsOn lists up to limit content metadata with segments or replicas on any of the storages, given
+	// by their base URLs, in the order of their hashes from the one after the given hash.
+	SegmentsOn(storages []string, after string, limit int) ([]*file.Meta, error)
+
+	// ReplaceSegments replaces the segment and replica URLs of the content, if they are still the ones
+	// of old, after segments were copied to other storages. It returns ErrNotFound if the content was
+	// changed or released meanwhile.
+	ReplaceSegments(hash string, old *file.Meta, segments, replicas []string) error
+
+	// Namespace loads the namespace settings; unknown namespaces have the default settings.
+	Namespace(name string) (*file.Namespace, error)
+
+	// Namespaces lists the configured namespaces.
+	Namespaces() ([]*file.Namespace, error)
+
+	// SetNamespace stores the namespace settings.
+	SetNamespace(ns *file.Namespace) error
+
+	// Rules lists the lifecycle rules.
+	Rules() ([]*file.Rule, error)
+
+	// SetRule stores the lifecycle rule.
+	SetRule(rule *file.Rule) error
+
+	// DeleteRule deletes the lifecycle rule.
+	DeleteRule(id string) error
+
+	// Policy loads the access policy of the principal.
+	Policy(principal string) (*file.Policy, error)
+
+	// Policies lists the access policies.
+	Policies() ([]*file.Policy, error)
+
+	// SetPolicy stores the access policy of its principal.
+	SetPolicy(policy *file.Policy) error
+
+	// DeletePolicy deletes the access policy of the principal.
+	DeletePolicy(principal string) error
+
+	// Usage returns the usage of the principal or namespace, zero if nothing is accounted to it.
+	// The usage is updated with the versions in the same transaction.
+	Usage(scope, name string) (*file.Usage, error)
+
+	// Usages lists the usage of the principals and namespaces.
+	Usages() ([]*file.Usage, error)
+
+	// Quota loads the quota of the principal or namespace.
+	Quota(scope, name string) (*file.Quota, error)
+
+	// Quotas lists the quotas.
+	Quotas() ([]*file.Quota, error)
+
+	// SetQuota stores the quota of its principal or namespace.
+	SetQuota(quota *file.Quota) error
+
+	// DeleteQuota deletes the quota of the principal or namespace.
+	DeleteQuota(scope, name string) error
+
+	// Close releases the underlying resources.
+	Close() error
+}
+
+// StoreOptions control how Store treats an existing name.
+type StoreOptions struct {
+	// Versioned stores the file under a new version id and keeps the previous versions.
+	// Otherwise the file gets the null version.
+	Versioned bool
+
+	// Overwrite replaces the null version of an existing name; without it,
+	// or a matching IfMatch, an existing name is refused.
+	Overwrite bool
+
+	// IfMatch requires the latest version to have this hash, "*" matches any existing version.
+	IfMatch string
+
+	// IfNoneMatch requires the latest version not to have this hash, "*" requires the name to be new.
+	IfNoneMatch string
+}
+
+// Check verifies the options against the latest version of the name, nil if there is none.
+func (o StoreOptions) Check(latest *file.Info) error {
+	exists := latest != nil
+
+	switch {
+	case o.IfMatch != "" && !exists,
+		o.IfMatch != "" && o.IfMatch != "*" && o.IfMatch != latest.Hash,
+		o.IfNoneMatch == "*" && exists,
+		o.IfNoneMatch != "" && exists && o.IfNoneMatch == latest.Hash:
+		return ErrPrecondition
+
+	case exists && !o.Versioned && !o.Overwrite && o.IfMatch == "":
+		return fmt.Errorf("file with name '%s': %w", latest.Name, ErrExists)
+	}
+	return nil
+}
+
+// ListOptions select the files to list.
+type ListOptions struct {
+	// Prefix is the name prefix of the files.
+	Prefix string
+
+	// Versions lists all versions, newest first, instead of the latest ones only.
+	Versions bool
+
+	// ContentType, if set, must be equal to the content type of the file.
+	ContentType string
+
+	// Meta holds the user metadata values the file must have.
+	Meta map[string]string
+}
+
+// match reports whether the version matches the metadata filters of the options.
+func (o ListOptions) match(fileInfo *file.Info) bool {
+	if o.ContentType != "" && fileInfo.ContentType != o.ContentType {
+		return false
+	}
+
+	for key, value := range o.Meta {
+		if stored, found := fileInfo.UserMeta[key]; !found || stored != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Open opens the metadata store selected by the scheme of the connection string:
+//
+//	mongodb://host:port/db, mongodb+srv://host/db - MongoDB
+//	file:///path/to/meta.log                      - embedded log-structured file
+//	memory://                                     - in-memory, lost on restart
+func Open(uri string) (DB, error) {
+	switch {
+	case strings.HasPrefix(uri, "memory://"):
+		return NewMemory(), nil
+
+	case strings.HasPrefix(uri, "file://"):
+		return OpenFile(strings.TrimPrefix(uri, "file://"))
+	}
+	return Connect(uri)
+}
+
+// newVersion returns a version id that sorts in creation order.
+func newVersion(now time.Time) string {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	return fmt.Sprintf("%016x%s", now.UnixNano(), hex.EncodeToString(suffix[:]))
+}
+
+// scopeKey returns the key of the usage and quota of a principal or namespace.
+func scopeKey(scope, name string) string {
+	return scope + "/" + name
+}
+
+// versionDoc returns the file info as it is kept in the files collection, without the content metadata.
+func versionDoc(fileInfo *file.Info) file.Info {
+	doc := *fileInfo
+	doc.Latest = false
+	doc.Size = 0
+	doc.Metadata = nil
+	doc.Encryption = nil
+	doc.Compression = ""
+	doc.StoredSize = 0
+	doc.SegmentSizes = nil
+	doc.StorageClass = ""
+	return doc
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// journal is an append-only log of committed transactions, one JSON line each.
+type journal struct {
+	f *os.File
+}
+
+// OpenFile opens the embedded metadata store kept in a single log-structured file.
+// The log is replayed into memory and compacted to one line per row on open.
+func OpenFile(path string) (*Memory, error) {
+	if path == "" {
+		return nil, errors.New("invalid metadata file path")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	m := NewMemory()
+	if err := m.replay(path); err != nil {
+		return nil, err
+	}
+	m.rebuildUsage()
+
+	j, err := m.compact(path)
+	if err != nil {
+		return nil, err
+	}
+	m.journal = j
+	return m, nil
+}
+
+// replay applies every complete transaction found in the log.
+// A torn last line, left by a crash in the middle of a write, is ignored.
+func (m *Memory) replay(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Metadata journal %s: ignoring incomplete line %d", path, n)
+			}
+			return nil
+
+		} else if err != nil {
+			return err
+		}
+
+		var ops []op
+		if err = json.Unmarshal(line, &ops); err != nil {
+			log.Printf("Metadata journal %s: ignoring corrupted line %d: %v", path, n, err)
+			return nil
+		}
+
+		if err = m.apply(ops); err != nil {
+			return err
+		}
+	}
+}
+
+// compact rewrites the log with the current rows and opens it for appending.
+func (m *Memory) compact(path string) (*journal, error) {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{f: f}
+	for name, t := range m.tables {
+		err = t.dump(func(key string, value json.RawMessage) error {
+			return j.append([]op{{Table: name, Key: key, Value: value}})
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+
+	f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f}, nil
+}
+
+// write appends the transaction and flushes it to disk.
+// A failed write is cut off so the log never has garbage in the middle.
+func (j *journal) write(ops []op) error {
+	offset, err := j.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if err = j.append(ops); err == nil {
+		err = j.f.Sync()
+	}
+
+	if err != nil {
+		j.f.Truncate(offset)
+	}
+	return err
+}
+
+func (j *journal) append(ops []op) error {
+	line, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	_, err = j.f.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the log file.
+func (j *journal) Close() error {
+	return j.f.Close()
+}
@@ -0,0 +1,961 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dcloud/internal/file"
+)
+
+// Memory is an in-memory metadata store. With a journal attached it
+// becomes the embedded file store, see OpenFile.
+type Memory struct {
+	sync.RWMutex
+	files      *table[fileEntry]
+	metadata   *table[file.Meta]
+	namespaces *table[file.Namespace]
+	rules      *table[file.Rule]
+	policies   *table[file.Policy]
+	quotas     *table[file.Quota]
+
+	// usage is derived from the versions and their content, so it is rebuilt instead of journaled
+	usage map[string]*file.Usage
+
+	tables  map[string]applier
+	journal *journal
+}
+
+// fileEntry holds all versions of a name, oldest first.
+type fileEntry struct {
+	Name     string      `json:"name"`
+	Versions []file.Info `json:"versions"`
+}
+
+// NewMemory creates an empty in-memory metadata store.
+func NewMemory() *Memory {
+	m := &Memory{
+		files:      newTable[fileEntry](),
+		metadata:   newTable[file.Meta](),
+		namespaces: newTable[file.Namespace](),
+		rules:      newTable[file.Rule](),
+		policies:   newTable[file.Policy](),
+		quotas:     newTable[file.Quota](),
+		usage:      make(map[string]*file.Usage),
+	}
+
+	m.tables = map[string]applier{
+		filesCollection:      m.files,
+		metadataCollection:   m.metadata,
+		namespacesCollection: m.namespaces,
+		lifecycleCollection:  m.rules,
+		policiesCollection:   m.policies,
+		quotasCollection:     m.quotas,
+	}
+	return m
+}
+
+// Store stores a new version of the file and its metadata.
+func (m *Memory) Store(fileInfo *file.Info, opts StoreOptions) ([]*file.Meta, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	tx := &tx{}
+	changed := make(map[string]*file.Meta)
+
+	removed, err := m.store(tx, changed, fileInfo, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	released := m.unref(tx, changed, removed)
+	return released, m.commit(tx)
+}
+
+// Move stores dst linked to the content of src and deletes the src version.
+func (m *Memory) Move(src, dst *file.Info, opts StoreOptions) ([]*file.Meta, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	tx := &tx{}
+	changed := make(map[string]*file.Meta)
+
+	dst.Hash = src.Hash
+	dst.Metadata = nil
+	dst.Replicas = nil
+
+	if entry, _ := m.files.get(src.Name); len(entry.Versions) > 1 {
+		return nil, fmt.Errorf("'%s': %w", src.Name, ErrVersions)
+	}
+
+	replaced, err := m.store(tx, changed, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := m.remove(tx, src.Name, src.Version, src.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	released := m.unref(tx, changed, append(replaced, removed...))
+	return released, m.commit(tx)
+}
+
+// store adds a new version of the file to the transaction and references its content.
+// It returns the versions replaced by the new one. The options are checked against the latest
+// version under the lock of the caller, so conditional writes are compare-and-set.
+func (m *Memory) store(tx *tx, changed map[string]*file.Meta, fileInfo *file.Info, opts StoreOptions) ([]file.Info, error) {
+	entry, _ := m.files.get(fileInfo.Name)
+
+	var latest *file.Info
+	if len(entry.Versions) > 0 {
+		latest = &entry.Versions[len(entry.Versions)-1]
+	}
+
+	if err := opts.Check(latest); err != nil {
+		return nil, err
+	}
+
+	metadata := m.meta(changed, fileInfo.Hash)
+	if metadata == nil {
+		if len(fileInfo.Metadata) == 0 {
+			return nil, fmt.Errorf("metadata with hash '%s': %w", fileInfo.Hash, ErrNotFound)
+		}
+
+		metadata = &file.Meta{
+			Hash:         fileInfo.Hash,
+			Size:         fileInfo.Size,
+			Metadata:     fileInfo.Metadata,
+			Replicas:     fileInfo.Replicas,
+			Encryption:   fileInfo.Encryption,
+			Compression:  fileInfo.Compression,
+			StoredSize:   fileInfo.StoredSize,
+			SegmentSizes: fileInfo.SegmentSizes,
+			StorageClass: fileInfo.StorageClass,
+		}
+		changed[metadata.Hash] = metadata
+	}
+	metadata.Refs++
+	tx.charge(fileInfo, metadata, 1)
+
+	var removed []file.Info
+	if !opts.Versioned {
+		// the new null version replaces the old one
+		kept := entry.Versions[:0]
+		for _, doc := range entry.Versions {
+			if doc.Version == file.NullVersion {
+				removed = append(removed, doc)
+			} else {
+				kept = append(kept, doc)
+			}
+		}
+		entry.Versions = kept
+	}
+
+	now := time.Now()
+	fileInfo.Version = file.NullVersion
+	if opts.Versioned {
+		fileInfo.Version = newVersion(now)
+	}
+	fileInfo.Created = now
+
+	entry.Name = fileInfo.Name
+	entry.Versions = append(entry.Versions, versionDoc(fileInfo))
+	fileInfo.Metadata = metadata.Metadata
+	fileInfo.Replicas = metadata.Replicas
+
+	tx.put(filesCollection, entry.Name, entry)
+	return removed, nil
+}
+
+// Load loads the latest version of the file by name or, if not found, by hash.
+func (m *Memory) Load(name string, hash ...string) (*file.Info, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if entry, found := m.files.get(name); found && len(entry.Versions) > 0 {
+		return m.info(entry.Versions[len(entry.Versions)-1], true), nil
+	}
+
+	if len(hash) > 0 {
+		if metadata, found := m.metadata.get(hash[0]); found {
+			return &file.Info{
+				Hash:         hash[0],
+				Size:         metadata.Size,
+				Metadata:     metadata.Metadata,
+				Replicas:     metadata.Replicas,
+				Encryption:   metadata.Encryption,
+				Compression:  metadata.Compression,
+				StoredSize:   metadata.StoredSize,
+				SegmentSizes: metadata.SegmentSizes,
+				StorageClass: metadata.StorageClass,
+			}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// LoadVersion loads the given version of the file.
+func (m *Memory) LoadVersion(name, version string) (*file.Info, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	entry, _ := m.files.get(name)
+	for i, doc := range entry.Versions {
+		if doc.Version == version {
+			return m.info(doc, i == len(entry.Versions)-1), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// LoadMeta loads the content metadata by hash.
+func (m *Memory) LoadMeta(hash string) (*file.Meta, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	metadata, found := m.metadata.get(hash)
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &metadata, nil
+}
+
+// List lists the files matching the options.
+func (m *Memory) List(opts ListOptions) ([]*file.Info, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var list []*file.Info
+	for _, name := range m.files.keys() {
+		if !strings.HasPrefix(name, opts.Prefix) {
+			continue
+		}
+
+		entry, _ := m.files.get(name)
+		last := len(entry.Versions) - 1
+		for i := last; i >= 0; i-- {
+			if !opts.Versions && i != last {
+				break
+			}
+
+			if !opts.match(&entry.Versions[i]) {
+				continue
+			}
+
+			fileInfo := m.info(entry.Versions[i], i == last)
+			fileInfo.Metadata = nil
+			list = append(list, fileInfo)
+		}
+	}
+	return list, nil
+}
+
+// Delete deletes one or all versions of the file and releases unreferenced content.
+func (m *Memory) Delete(name, version string, created ...time.Time) ([]*file.Meta, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	tx := &tx{}
+	removed, err := m.remove(tx, name, version, created...)
+	if err != nil {
+		return nil, err
+	}
+
+	released := m.unref(tx, make(map[string]*file.Meta), removed)
+	return released, m.commit(tx)
+}
+
+// remove adds the deletion of the matching versions to the transaction and returns them.
+func (m *Memory) remove(tx *tx, name, version string, created ...time.Time) ([]file.Info, error) {
+	entry, found := m.files.get(name)
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	var removed []file.Info
+	kept := entry.Versions[:0]
+	for _, doc := range entry.Versions {
+		if (version == "" || doc.Version == version) && (len(created) == 0 || doc.Created.Equal(created[0])) {
+			removed = append(removed, doc)
+		} else {
+			kept = append(kept, doc)
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil, ErrNotFound
+	}
+
+	if entry.Versions = kept; len(kept) == 0 {
+		tx.delete(filesCollection, name)
+	} else {
+		tx.put(filesCollection, name, entry)
+	}
+	return removed, nil
+}
+
+// meta returns the metadata changed by the transaction, loading it on first use; nil if there is none.
+func (m *Memory) meta(changed map[string]*file.Meta, hash string) *file.Meta {
+	if metadata, found := changed[hash]; found {
+		return metadata
+	}
+
+	metadata, found := m.metadata.get(hash)
+	if !found {
+		return nil
+	}
+	changed[hash] = &metadata
+	return &metadata
+}
+
+// unref drops the references of the removed versions and adds the changed metadata to the transaction.
+// It returns the metadata of the content that is no longer referenced.
+func (m *Memory) unref(tx *tx, changed map[string]*file.Meta, removed []file.Info) []*file.Meta {
+	for _, doc := range removed {
+		if metadata := m.meta(changed, doc.Hash); metadata != nil {
+			metadata.Refs--
+			tx.charge(&doc, metadata, -1)
+		}
+	}
+
+	var released []*file.Meta
+	for hash, metadata := range changed {
+		if metadata.Refs > 0 {
+			tx.put(metadataCollection, hash, metadata)
+			continue
+		}
+		tx.delete(metadataCollection, hash)
+		released = append(released, metadata)
+	}
+	return released
+}
+
+// Expiring lists the versions whose expiry time is not after now.
+func (m *Memory) Expiring(now time.Time, limit int) ([]*file.Info, error) {
+	return m.find(limit, func(doc *file.Info) bool {
+		return doc.Expired(now)
+	})
+}
+
+// Older lists the versions with the name prefix stored before the given time.
+func (m *Memory) Older(prefix string, before time.Time, limit int) ([]*file.Info, error) {
+	return m.find(limit, func(doc *file.Info) bool {
+		return strings.HasPrefix(doc.Name, prefix) && doc.Created.Before(before)
+	})
+}
+
+// find lists up to limit versions matching the filter.
+func (m *Memory) find(limit int, match func(doc *file.Info) bool) ([]*file.Info, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var list []*file.Info
+	for _, entry := range m.files.rows {
+		for i := range entry.Versions {
+			if len(list) >= limit {
+				return list, nil
+			}
+
+			if doc := entry.Versions[i]; match(&doc) {
+				list = append(list, &doc)
+			}
+		}
+	}
+	return list, nil
+}
+
+// StaleEncryption lists content metadata whose data key is wrapped with another master key.
+func (m *Memory) StaleEncryption(current, after string, limit int) ([]*file.Meta, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var list []*file.Meta
+	for _, hash := range m.metadata.keys() {
+		if len(list) >= limit {
+			break
+		}
+		if hash <= after {
+			continue
+		}
+		if metadata, _ := m.metadata.get(hash); metadata.Encryption != nil && metadata.Encryption.KeyID != "" && metadata.Encryption.KeyID != current {
+			list = append(list, &metadata)
+		}
+	}
+	return list, nil
+}
+
+// SetEncryption replaces the encryption description of the content.
+func (m *Memory) SetEncryption(hash string, enc *file.Encryption) error {
+	m.Lock()
+	defer m.Unlock()
+
+	metadata, found := m.metadata.get(hash)
+	if !found {
+		return ErrNotFound
+	}
+	metadata.Encryption = enc
+
+	tx := &tx{}
+	tx.put(metadataCollection, hash, &metadata)
+	return m.commit(tx)
+}
+
+// Names lists the names with a version referencing the content.
+func (m *Memory) Names(hash string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var names []string
+	for name, entry := range m.files.rows {
+		if slices.ContainsFunc(entry.Versions, func(doc file.Info) bool { return doc.Hash == hash }) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// SegmentInUse reports whether any content metadata refers to the segment URL.
+func (m *Memory) SegmentInUse(url string) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, metadata := range m.metadata.rows {
+		if slices.Contains(metadata.Metadata, url) || slices.Contains(metadata.Replicas, url) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Transitions lists content metadata the transition rule moves.
+func (m *Memory) Transitions(rule *file.Rule, now time.Time, limit int) ([]*file.Meta, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	before := now.AddDate(0, 0, -rule.Days)
+	seen := make(map[string]bool)
+
+	var list []*file.Meta
+	for _, entry := range m.files.rows {
+		for _, doc := range entry.Versions {
+			if len(list) >= limit {
+				return list, nil
+			}
+			if seen[doc.Hash] || !strings.HasPrefix(doc.Name, rule.Prefix) || !doc.Created.Before(before) {
+				continue
+			}
+			seen[doc.Hash] = true
+
+			if metadata, found := m.metadata.get(doc.Hash); found && rule.Moves(&metadata, now) {
+				list = append(list, &metadata)
+			}
+		}
+	}
+	return list, nil
+}
+
+// MoveSegments replaces the segment and replica URLs of the content if they are still the old ones.
+func (m *Memory) MoveSegments(hash string, old *file.Meta, segments, replicas []string, class string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	metadata, found := m.metadata.get(hash)
+	if !found || !sameSegments(&metadata, old) {
+		return ErrNotFound
+	}
+	metadata.Metadata = segments
+	metadata.Replicas = replicas
+	metadata.StorageClass = class
+	metadata.Reads = 0
+
+	tx := &tx{}
+	tx.put(metadataCollection, hash, &metadata)
+	return m.commit(tx)
+}
+
+// SegmentsOn lists content metadata with segments on the storages.
+func (m *Memory) SegmentsOn(storages []string, after string, limit int) ([]*file.Meta, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var list []*file.Meta
+	for _, hash := range m.metadata.keys() {
+		if len(list) >= limit {
+			break
+		}
+		if hash <= after {
+			continue
+		}
+
+		metadata, _ := m.metadata.get(hash)
+		if slices.ContainsFunc(metadata.Segments(), func(segmentURL string) bool {
+			return slices.ContainsFunc(storages, func(storage string) bool {
+				return strings.HasPrefix(segmentURL, storage+"/")
+			})
+		}) {
+			list = append(list, &metadata)
+		}
+	}
+	return list, nil
+}
+
+// ReplaceSegments replaces the segment and replica URLs of the content if they are still the old ones.
+func (m *Memory) ReplaceSegments(hash string, old *file.Meta, segments, replicas []string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	metadata, found := m.metadata.get(hash)
+	if !found || !sameSegments(&metadata, old) {
+		return ErrNotFound
+	}
+	metadata.Metadata = segments
+	metadata.Replicas = replicas
+
+	tx := &tx{}
+	tx.put(metadataCollection, hash, &metadata)
+	return m.commit(tx)
+}
+
+// sameSegments reports whether the content has still the segment and replica URLs of old.
+func sameSegments(metadata, old *file.Meta) bool {
+	return slices.Equal(metadata.Metadata, old.Metadata) && slices.Equal(metadata.Replicas, old.Replicas)
+}
+
+// Touch counts reads of the content.
+func (m *Memory) Touch(hash string, reads int64, at time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	metadata, found := m.metadata.get(hash)
+	if !found {
+		return ErrNotFound
+	}
+	metadata.Reads += reads
+	if metadata.Accessed == nil || at.After(*metadata.Accessed) {
+		metadata.Accessed = &at
+	}
+
+	tx := &tx{}
+	tx.put(metadataCollection, hash, &metadata)
+	return m.commit(tx)
+}
+
+// Namespace loads the namespace settings.
+func (m *Memory) Namespace(name string) (*file.Namespace, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	ns, found := m.namespaces.get(name)
+	if !found {
+		ns.Name = name
+	}
+	return &ns, nil
+}
+
+// Namespaces lists the configured namespaces.
+func (m *Memory) Namespaces() ([]*file.Namespace, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var list []*file.Namespace
+	for _, name := range m.namespaces.keys() {
+		ns, _ := m.namespaces.get(name)
+		list = append(list, &ns)
+	}
+	return list, nil
+}
+
+// SetNamespace stores the namespace settings.
+func (m *Memory) SetNamespace(ns *file.Namespace) error {
+	m.Lock()
+	defer m.Unlock()
+
+	tx := &tx{}
+	tx.put(namespacesCollection, ns.Name, ns)
+	return m.commit(tx)
+}
+
+// Rules lists the lifecycle rules.
+func (m *Memory) Rules() ([]*file.Rule, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var list []*file.Rule
+	for _, id := range m.rules.keys() {
+		rule, _ := m.rules.get(id)
+		list = append(list, &rule)
+	}
+	return list, nil
+}
+
+// SetRule stores the lifecycle rule.
+func (m *Memory) SetRule(rule *file.Rule) error {
+	m.Lock()
+	defer m.Unlock()
+
+	tx := &tx{}
+	tx.put(lifecycleCollection, rule.ID, rule)
+	return m.commit(tx)
+}
+
+// DeleteRule deletes the lifecycle rule.
+func (m *Memory) DeleteRule(id string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, found := m.rules.get(id); !found {
+		return ErrNotFound
+	}
+
+	tx := &tx{}
+	tx.delete(lifecycleCollection, id)
+	return m.commit(tx)
+}
+
+// Policy loads the access policy of the principal.
+func (m *Memory) Policy(principal string) (*file.Policy, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	policy, found := m.policies.get(principal)
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &policy, nil
+}
+
+// Policies lists the access policies.
+func (m *Memory) Policies() ([]*file.Policy, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var list []*file.Policy
+	for _, principal := range m.policies.keys() {
+		policy, _ := m.policies.get(principal)
+		list = append(list, &policy)
+	}
+	return list, nil
+}
+
+// SetPolicy stores the access policy of its principal.
+func (m *Memory) SetPolicy(policy *file.Policy) error {
+	m.Lock()
+	defer m.Unlock()
+
+	tx := &tx{}
+	tx.put(policiesCollection, policy.Principal, policy)
+	return m.commit(tx)
+}
+
+// DeletePolicy deletes the access policy of the principal.
+func (m *Memory) DeletePolicy(principal string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, found := m.policies.get(principal); !found {
+		return ErrNotFound
+	}
+
+	tx := &tx{}
+	tx.delete(policiesCollection, principal)
+	return m.commit(tx)
+}
+
+// Usage returns the usage of the principal or namespace.
+func (m *Memory) Usage(scope, name string) (*file.Usage, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if usage, found := m.usage[scopeKey(scope, name)]; found {
+		copied := *usage
+		return &copied, nil
+	}
+	return &file.Usage{Scope: scope, Name: name}, nil
+}
+
+// Usages lists the usage of the principals and namespaces.
+func (m *Memory) Usages() ([]*file.Usage, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	keys := make([]string, 0, len(m.usage))
+	for key := range m.usage {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]*file.Usage, len(keys))
+	for i, key := range keys {
+		copied := *m.usage[key]
+		list[i] = &copied
+	}
+	return list, nil
+}
+
+// Quota loads the quota of the principal or namespace.
+func (m *Memory) Quota(scope, name string) (*file.Quota, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	quota, found := m.quotas.get(scopeKey(scope, name))
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &quota, nil
+}
+
+// Quotas lists the quotas.
+func (m *Memory) Quotas() ([]*file.Quota, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var list []*file.Quota
+	for _, key := range m.quotas.keys() {
+		quota, _ := m.quotas.get(key)
+		list = append(list, &quota)
+	}
+	return list, nil
+}
+
+// SetQuota stores the quota of its principal or namespace.
+func (m *Memory) SetQuota(quota *file.Quota) error {
+	m.Lock()
+	defer m.Unlock()
+
+	tx := &tx{}
+	tx.put(quotasCollection, scopeKey(quota.Scope, quota.Name), quota)
+	return m.commit(tx)
+}
+
+// DeleteQuota deletes the quota of the principal or namespace.
+func (m *Memory) DeleteQuota(scope, name string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	key := scopeKey(scope, name)
+	if _, found := m.quotas.get(key); !found {
+		return ErrNotFound
+	}
+
+	tx := &tx{}
+	tx.delete(quotasCollection, key)
+	return m.commit(tx)
+}
+
+// info returns the file info of the version with its content metadata.
+func (m *Memory) info(doc file.Info, latest bool) *file.Info {
+	fileInfo := doc
+	fileInfo.Latest = latest
+	if metadata, found := m.metadata.get(doc.Hash); found {
+		fileInfo.Size = metadata.Size
+		fileInfo.Metadata = metadata.Metadata
+		fileInfo.Replicas = metadata.Replicas
+		fileInfo.Encryption = metadata.Encryption
+		fileInfo.Compression = metadata.Compression
+		fileInfo.StoredSize = metadata.StoredSize
+		fileInfo.SegmentSizes = metadata.SegmentSizes
+		fileInfo.StorageClass = metadata.StorageClass
+	}
+	return &fileInfo
+}
+
+// Close closes the journal, if any.
+func (m *Memory) Close() error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.journal == nil {
+		return nil
+	}
+	return m.journal.Close()
+}
+
+// commit writes the transaction to the journal and applies it to the tables.
+// The caller must hold the write lock.
+func (m *Memory) commit(tx *tx) error {
+	if tx.err != nil {
+		return tx.err
+	}
+
+	if m.journal != nil {
+		if err := m.journal.write(tx.ops); err != nil {
+			return err
+		}
+	}
+
+	if err := m.apply(tx.ops); err != nil {
+		return err
+	}
+
+	for key, delta := range tx.usage {
+		m.account(key, delta)
+	}
+	return nil
+}
+
+// account adds the delta to the usage of the key.
+// The caller must hold the write lock.
+func (m *Memory) account(key string, delta *file.Usage) {
+	usage, found := m.usage[key]
+	if !found {
+		usage = &file.Usage{Scope: delta.Scope, Name: delta.Name}
+		m.usage[key] = usage
+	}
+
+	usage.Objects += delta.Objects
+	usage.Bytes += delta.Bytes
+	usage.StoredBytes += delta.StoredBytes
+
+	if usage.Objects <= 0 {
+		delete(m.usage, key)
+	}
+}
+
+// rebuildUsage accounts every version to its namespace and owner.
+func (m *Memory) rebuildUsage() {
+	tx := &tx{}
+	for _, entry := range m.files.rows {
+		for i := range entry.Versions {
+			if metadata, found := m.metadata.rows[entry.Versions[i].Hash]; found {
+				tx.charge(&entry.Versions[i], &metadata, 1)
+			}
+		}
+	}
+
+	m.usage = make(map[string]*file.Usage)
+	for key, delta := range tx.usage {
+		m.account(key, delta)
+	}
+}
+
+// apply applies the operations to the tables.
+func (m *Memory) apply(ops []op) error {
+	for _, op := range ops {
+		t, found := m.tables[op.Table]
+		if !found {
+			return fmt.Errorf("unknown collection '%s'", op.Table)
+		}
+
+		if err := t.apply(op.Key, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// op is a single change of a table row. A nil value deletes the row.
+type op struct {
+	Table string          `json:"t"`
+	Key   string          `json:"k"`
+	Value json.RawMessage `json:"v,omitempty"`
+}
+
+// tx collects the changes that are applied atomically.
+type tx struct {
+	ops   []op
+	usage map[string]*file.Usage
+	err   error
+}
+
+// charge adds n versions of the content to the usage of the scopes of the version.
+func (t *tx) charge(doc *file.Info, metadata *file.Meta, n int64) {
+	if t.usage == nil {
+		t.usage = make(map[string]*file.Usage)
+	}
+
+	for _, scope := range doc.UsageScopes() {
+		key := scopeKey(scope[0], scope[1])
+		delta, found := t.usage[key]
+		if !found {
+			delta = &file.Usage{Scope: scope[0], Name: scope[1]}
+			t.usage[key] = delta
+		}
+
+		delta.Objects += n
+		delta.Bytes += n * metadata.Size
+		delta.StoredBytes += n * metadata.Stored()
+	}
+}
+
+func (t *tx) put(table, key string, value any) {
+	raw, err := json.Marshal(value)
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	t.ops = append(t.ops, op{Table: table, Key: key, Value: raw})
+}
+
+func (t *tx) delete(table, key string) {
+	t.ops = append(t.ops, op{Table: table, Key: key})
+}
+
+// applier is implemented by every table regardless of its row type.
+type applier interface {
+	apply(key string, value json.RawMessage) error
+	dump(fn func(key string, value json.RawMessage) error) error
+}
+
+// table is a collection of rows by key. Rows go in and out as JSON copies,
+// so callers never share memory with the store.
+type table[T any] struct {
+	rows map[string]T
+}
+
+func newTable[T any]() *table[T] {
+	return &table[T]{rows: make(map[string]T)}
+}
+
+func (t *table[T]) get(key string) (row T, found bool) {
+	stored, found := t.rows[key]
+	if !found {
+		return row, false
+	}
+
+	raw, _ := json.Marshal(stored)
+	json.Unmarshal(raw, &row)
+	return row, true
+}
+
+// keys returns the keys in sorted order.
+func (t *table[T]) keys() []string {
+	keys := make([]string, 0, len(t.rows))
+	for key := range t.rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (t *table[T]) apply(key string, value json.RawMessage) error {
+	if value == nil {
+		delete(t.rows, key)
+		return nil
+	}
+
+	var row T
+	if err := json.Unmarshal(value, &row); err != nil {
+		return err
+	}
+	t.rows[key] = row
+	return nil
+}
+
+func (t *table[T]) dump(fn func(key string, value json.RawMessage) error) error {
+	for key, row := range t.rows {
+		raw, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(key, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"dcloud/internal/file"
 	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
@@ -16,13 +17,27 @@ import (
 const (
 	filesCollection    = "files"
 	metadataCollection = "metadata"
+	namespacesCollection = "namespaces"
+	lifecycleCollection = "lifecycle"
+	policiesCollection = "policies"
+	quotasCollection = "quotas"
+	usageCollection = "usage"
+	headsCollection = "heads"
 	timeout = 5 * time.Second
 )
 
 type MongoDB struct {
-	client   *mongo.Client
-	files    *mongo.Collection
-	metadata *mongo.Collection
+	client     *mongo.Client
+	files      *mongo.Collection
+	metadata   *mongo.Collection
+	namespaces *mongo.Collection
+	lifecycle  *mongo.Collection
+	policies   *mongo.Collection
+	quotas     *mongo.Collection
+	usage      *mongo.Collection
+
+	// heads holds a document per name that every store of the name updates, see store
+	heads      *mongo.Collection
 }
 
 // Connect connects to the MongoDB and returns a new MongoDB instance.
@@ -48,14 +63,22 @@ func Connect(uri string) (*MongoDB, error) {
 
 	// ------------------------------------------------------------------------------------------- files
 	files := client.Database(dbName).Collection(filesCollection)
+
+	// names were unique before versioning, every version has its own document now
+	files.Indexes().DropOne(context.Background(), "name_1")
+
 	indexModel := []mongo.IndexModel{
 		{
-			Keys:    bson.M{"name": 1},
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "version", Value: 1}},
 			Options: options.Index().SetUnique(true),
 		},
 		{
 			Keys:    bson.M{"hash": 1},
 		},
+		{
+			Keys:    bson.M{"expires": 1},
+			Options: options.Index().SetSparse(true),
+		},
 	}
 
 	if _, err := files.Indexes().CreateMany(context.Background(), indexModel); err != nil {
@@ -70,6 +93,9 @@ func Connect(uri string) (*MongoDB, error) {
 			Keys:    bson.M{"hash": 1},
 			Options: options.Index().SetUnique(true),
 		},
+		{
+			Keys:    bson.M{"metadata": 1},
+		},
 	}
 
 	if _, err := metadata.Indexes().CreateMany(context.Background(), indexModel); err != nil {
@@ -77,87 +103,982 @@ func Connect(uri string) (*MongoDB, error) {
 	}
 	// ------------------------------------------------------------------------------------------- /metadata
 
-	return &MongoDB{
-		client:   client,
-		files:    files,
-		metadata: metadata,
-	}, nil
-}
-
-// Store stores the file info and metadata in the MongoDB.
-func (m *MongoDB) Store(fileInfo *file.Info) (err error) {
-    // Check if the file already exists in the 'files' collection
-    filter := bson.M{"name": fileInfo.Name} //, "hash": file.Hash}
-    count, err := m.files.CountDocuments(context.Background(), filter)
-    if err != nil {
-        return err
-    }
-
-    if count > 0 {
-        return fmt.Errorf("file with name '%s' and hash '%s' already exists", fileInfo.Name, fileInfo.Hash)
-    }
-
-    _, err = m.files.InsertOne(context.Background(), struct{
-        Name string `bson:"name"`
-        Hash string `bson:"hash"`
-    }{
-        Name: fileInfo.Name,
-        Hash: fileInfo.Hash,
-    })
-
-    if err != nil {
-        return err
-    }
-
-    if len(fileInfo.Metadata) == 0 {
-        return nil
-    }
-
-    metadataFilter := bson.M{"hash": fileInfo.Hash}
-    count, err = m.metadata.CountDocuments(context.Background(), metadataFilter)
-    if err != nil {
-        return err
-    }
-
-    if count > 0 {
-        return fmt.Errorf("metadata with hash '%s' already exists", fileInfo.Hash)
-    }
-
-    _, err = m.metadata.InsertOne(context.Background(), file.Meta{
-        Hash: fileInfo.Hash,
-        Size: fileInfo.Size,
-        Metadata: fileInfo.Metadata,
-    })
-    return err
-}
-
-// Load loads the file info from the MongoDB by name or hash.
+	// ------------------------------------------------------------------------------------------- namespaces
+	namespaces := client.Database(dbName).Collection(namespacesCollection)
+	indexModel = []mongo.IndexModel{
+		{
+			Keys:    bson.M{"name": 1},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	if _, err := namespaces.Indexes().CreateMany(context.Background(), indexModel); err != nil {
+		return nil, err
+	}
+	// ------------------------------------------------------------------------------------------- /namespaces
+
+	// ------------------------------------------------------------------------------------------- lifecycle
+	lifecycle := client.Database(dbName).Collection(lifecycleCollection)
+	indexModel = []mongo.IndexModel{
+		{
+			Keys:    bson.M{"id": 1},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	if _, err := lifecycle.Indexes().CreateMany(context.Background(), indexModel); err != nil {
+		return nil, err
+	}
+	// ------------------------------------------------------------------------------------------- /lifecycle
+
+	// ------------------------------------------------------------------------------------------- policies
+	policies := client.Database(dbName).Collection(policiesCollection)
+	indexModel = []mongo.IndexModel{
+		{
+			Keys:    bson.M{"principal": 1},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	if _, err := policies.Indexes().CreateMany(context.Background(), indexModel); err != nil {
+		return nil, err
+	}
+	// ------------------------------------------------------------------------------------------- /policies
+
+	// ------------------------------------------------------------------------------------------- quotas, usage
+	quotas := client.Database(dbName).Collection(quotasCollection)
+	usage := client.Database(dbName).Collection(usageCollection)
+	indexModel = []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "scope", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	for _, collection := range []*mongo.Collection{quotas, usage} {
+		if _, err := collection.Indexes().CreateMany(context.Background(), indexModel); err != nil {
+			return nil, err
+		}
+	}
+	// ------------------------------------------------------------------------------------------- /quotas, usage
+
+	db := &MongoDB{
+		client:     client,
+		files:      files,
+		metadata:   metadata,
+		namespaces: namespaces,
+		lifecycle:  lifecycle,
+		policies:   policies,
+		quotas:     quotas,
+		usage:      usage,
+		heads:      client.Database(dbName).Collection(headsCollection),
+	}
+
+	if err = db.migrate(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrate brings the documents written before versioning up to date:
+// files get the null version and metadata gets its reference count.
+// The usage is built from the stored versions if it was never accounted.
+func (m *MongoDB) migrate() error {
+	ctx := context.Background()
+
+	_, err := m.files.UpdateMany(ctx,
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"version": file.NullVersion}},
+	)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := m.metadata.Find(ctx, bson.M{"refs": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var metadata file.Meta
+		if err = cursor.Decode(&metadata); err != nil {
+			return err
+		}
+
+		refs, err := m.files.CountDocuments(ctx, bson.M{"hash": metadata.Hash})
+		if err != nil {
+			return err
+		}
+
+		_, err = m.metadata.UpdateOne(ctx, bson.M{"hash": metadata.Hash}, bson.M{"$set": bson.M{"refs": refs}})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = cursor.Err(); err != nil {
+		return err
+	}
+	return m.rebuildUsage(ctx)
+}
+
+// rebuildUsage accounts every version to its namespace and owner, unless the usage is accounted already.
+func (m *MongoDB) rebuildUsage(ctx context.Context) error {
+	if count, err := m.usage.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1)); err != nil || count > 0 {
+		return err
+	}
+
+	cursor, err := m.files.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "hash": 1, "owner": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var (
+		contents = make(map[string]*file.Meta)
+		usage    = make(map[string]*file.Usage)
+	)
+	for cursor.Next(ctx) {
+		var doc file.Info
+		if err = cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		metadata, found := contents[doc.Hash]
+		if !found {
+			if metadata, err = m.LoadMeta(doc.Hash); err != nil {
+				continue
+			}
+			contents[doc.Hash] = metadata
+		}
+
+		for _, scope := range doc.UsageScopes() {
+			key := scopeKey(scope[0], scope[1])
+			if usage[key] == nil {
+				usage[key] = &file.Usage{Scope: scope[0], Name: scope[1]}
+			}
+			usage[key].Objects++
+			usage[key].Bytes += metadata.Size
+			usage[key].StoredBytes += metadata.Stored()
+		}
+	}
+
+	if err = cursor.Err(); err != nil || len(usage) == 0 {
+		return err
+	}
+
+	docs := make([]any, 0, len(usage))
+	for _, u := range usage {
+		docs = append(docs, u)
+	}
+	_, err = m.usage.InsertMany(ctx, docs)
+	return err
+}
+
+// charge adds n versions of the content to the usage of the scopes of the version.
+func (m *MongoDB) charge(ctx mongo.SessionContext, doc *file.Info, metadata *file.Meta, n int64) error {
+	for _, scope := range doc.UsageScopes() {
+		_, err := m.usage.UpdateOne(ctx,
+			bson.M{"scope": scope[0], "name": scope[1]},
+			bson.M{"$inc": bson.M{
+				"objects":     n,
+				"bytes":       n * metadata.Size,
+				"storedBytes": n * metadata.Stored(),
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store stores a new version of the file and its metadata in the MongoDB.
+// Both collections are updated in one transaction, so a name never points to missing metadata.
+func (m *MongoDB) Store(fileInfo *file.Info, opts StoreOptions) (released []*file.Meta, err error) {
+	err = m.transaction(func(ctx mongo.SessionContext) (err error) {
+		released, err = m.store(ctx, fileInfo, opts)
+		return err
+	})
+	return released, err
+}
+
+// Move stores dst linked to the content of src and deletes the src version in one transaction.
+func (m *MongoDB) Move(src, dst *file.Info, opts StoreOptions) (released []*file.Meta, err error) {
+	dst.Hash = src.Hash
+	dst.Metadata = nil
+
+	err = m.transaction(func(ctx mongo.SessionContext) error {
+		replaced, err := m.store(ctx, dst, opts)
+		if err != nil {
+			return err
+		}
+
+		if err = m.touchHead(ctx, src.Name); err != nil {
+			return err
+		}
+
+		versions, err := m.files.CountDocuments(ctx, bson.M{"name": src.Name})
+		if err != nil {
+			return err
+		}
+		if versions > 1 {
+			return fmt.Errorf("'%s': %w", src.Name, ErrVersions)
+		}
+
+		removed, err := m.remove(ctx, bson.M{"name": src.Name, "version": src.Version, "created": src.Created})
+		if err != nil {
+			return err
+		}
+
+		released = append(replaced, removed...)
+		return nil
+	})
+	return released, err
+}
+
+// store inserts a new version of the file and references its content.
+// It returns the metadata released by the replaced null version.
+func (m *MongoDB) store(ctx mongo.SessionContext, fileInfo *file.Info, opts StoreOptions) (released []*file.Meta, err error) {
+	var (
+		doc    file.Info
+		latest *file.Info
+	)
+
+	err = m.files.FindOne(ctx, bson.M{"name": fileInfo.Name}, latestFirst()).Decode(&doc)
+	if err == nil {
+		latest = &doc
+
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	if err = opts.Check(latest); err != nil {
+		return nil, err
+	}
+
+	if err = m.touchHead(ctx, fileInfo.Name); err != nil {
+		return nil, err
+	}
+
+	var metadata file.Meta
+	if len(fileInfo.Metadata) == 0 {
+		// only a new name for the existing content
+		err = m.metadata.FindOneAndUpdate(ctx,
+			bson.M{"hash": fileInfo.Hash},
+			bson.M{"$inc": bson.M{"refs": 1}},
+		).Decode(&metadata)
+
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("metadata with hash '%s': %w", fileInfo.Hash, ErrNotFound)
+
+		} else if err != nil {
+			return nil, err
+		}
+
+	} else {
+		// the first writer of the content wins, later ones only get the name
+		err = m.metadata.FindOneAndUpdate(ctx,
+			bson.M{"hash": fileInfo.Hash},
+			bson.M{
+				"$setOnInsert": bson.M{
+					"hash":         fileInfo.Hash,
+					"size":         fileInfo.Size,
+					"metadata":     fileInfo.Metadata,
+					"replicas":     fileInfo.Replicas,
+					"encryption":   fileInfo.Encryption,
+					"compression":  fileInfo.Compression,
+					"storedSize":   fileInfo.StoredSize,
+					"segmentSizes": fileInfo.SegmentSizes,
+					"storageClass": fileInfo.StorageClass,
+				},
+				"$inc": bson.M{"refs": 1},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !opts.Versioned && latest != nil {
+		// the new null version replaces the old one
+		released, err = m.remove(ctx, bson.M{"name": fileInfo.Name, "version": file.NullVersion})
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	fileInfo.Version = file.NullVersion
+	if opts.Versioned {
+		fileInfo.Version = newVersion(now)
+	}
+	fileInfo.Created = now
+
+	if _, err = m.files.InsertOne(ctx, versionDoc(fileInfo)); err != nil {
+		return nil, err
+	}
+	fileInfo.Metadata = metadata.Metadata
+	fileInfo.Replicas = metadata.Replicas
+	return released, m.charge(ctx, fileInfo, &metadata, 1)
+}
+
+// transaction runs fn in a multi-document transaction, retrying it on transient errors.
+func (m *MongoDB) transaction(fn func(ctx mongo.SessionContext) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+		return nil, fn(ctx)
+	})
+
+	if mongo.IsDuplicateKeyError(err) {
+		err = fmt.Errorf("%v: %w", err, ErrExists)
+	}
+	return err
+}
+
+// Load loads the latest version of the file by name or, if not found, by hash.
 func (m *MongoDB) Load(name string, hash ...string) (*file.Info, error) {
-    var fileInfo file.Info
-
-    // Try to find by name first
-    err := m.files.FindOne(context.Background(), bson.M{"name": name}).Decode(&fileInfo)
-    if err == nil {
-        // Load metadata if file found
-        var metadata file.Meta
-        err = m.metadata.FindOne(context.Background(), bson.M{"hash": fileInfo.Hash}).Decode(&metadata)
-        if err == nil {
-            fileInfo.Size = metadata.Size
-            fileInfo.Metadata = metadata.Metadata
-        }
-        return &fileInfo, nil
-    }
-
-    // If not found by name and hash is provided, try finding by hash
-    if len(hash) > 0 {
-        var metadata file.Meta
-        err = m.metadata.FindOne(context.Background(), bson.M{"hash": hash[0]}).Decode(&metadata)
-        if err == nil {
-            fileInfo.Hash = hash[0]
-            fileInfo.Size = metadata.Size
-            fileInfo.Metadata = metadata.Metadata
-            return &fileInfo, nil
-        }
-    }
-    return nil, err
+	var fileInfo file.Info
+
+	err := m.files.FindOne(context.Background(), bson.M{"name": name}, latestFirst()).Decode(&fileInfo)
+	if err == nil {
+		fileInfo.Latest = true
+		return m.attach(&fileInfo), nil
+	}
+
+	// If not found by name and hash is provided, try finding by hash
+	if err == mongo.ErrNoDocuments && len(hash) > 0 {
+		var metadata *file.Meta
+		if metadata, err = m.LoadMeta(hash[0]); err == nil {
+			return &file.Info{
+				Hash:         hash[0],
+				Size:         metadata.Size,
+				Metadata:     metadata.Metadata,
+				Replicas:     metadata.Replicas,
+				Encryption:   metadata.Encryption,
+				Compression:  metadata.Compression,
+				StoredSize:   metadata.StoredSize,
+				SegmentSizes: metadata.SegmentSizes,
+				StorageClass: metadata.StorageClass,
+			}, nil
+		}
+	}
+	return nil, notFound(err)
+}
+
+// LoadVersion loads the given version of the file.
+func (m *MongoDB) LoadVersion(name, version string) (*file.Info, error) {
+	var fileInfo file.Info
+
+	err := m.files.FindOne(context.Background(), bson.M{"name": name, "version": version}).Decode(&fileInfo)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	var latest file.Info
+	if err = m.files.FindOne(context.Background(), bson.M{"name": name}, latestFirst()).Decode(&latest); err == nil {
+		fileInfo.Latest = latest.Version == version
+	}
+	return m.attach(&fileInfo), nil
+}
+
+// LoadMeta loads the content metadata by hash.
+func (m *MongoDB) LoadMeta(hash string) (*file.Meta, error) {
+	var metadata file.Meta
+
+	err := m.metadata.FindOne(context.Background(), bson.M{"hash": hash}).Decode(&metadata)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &metadata, nil
+}
+
+// List lists the files matching the options.
+// The metadata filters are applied to the versions read, so the latest version is known.
+func (m *MongoDB) List(opts ListOptions) ([]*file.Info, error) {
+	ctx := context.Background()
+
+	filter := bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(opts.Prefix)}}
+	sort := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "created", Value: -1}, {Key: "_id", Value: -1}})
+
+	cursor, err := m.files.Find(ctx, filter, sort)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var (
+		list   []*file.Info
+		hashes []string
+		last   string
+	)
+
+	for cursor.Next(ctx) {
+		var fileInfo file.Info
+		if err = cursor.Decode(&fileInfo); err != nil {
+			return nil, err
+		}
+
+		fileInfo.Latest = fileInfo.Name != last
+		last = fileInfo.Name
+
+		if !fileInfo.Latest && !opts.Versions || !opts.match(&fileInfo) {
+			continue
+		}
+		list = append(list, &fileInfo)
+		hashes = append(hashes, fileInfo.Hash)
+	}
+
+	if err = cursor.Err(); err != nil || len(list) == 0 {
+		return list, err
+	}
+
+	contents := make(map[string]*file.Meta)
+	cursor, err = m.metadata.Find(ctx, bson.M{"hash": bson.M{"$in": hashes}}, options.Find().SetProjection(bson.M{"metadata": 0, "segmentSizes": 0}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var metadata file.Meta
+		if err = cursor.Decode(&metadata); err != nil {
+			return nil, err
+		}
+		contents[metadata.Hash] = &metadata
+	}
+
+	for _, fileInfo := range list {
+		if metadata, found := contents[fileInfo.Hash]; found {
+			fileInfo.Size = metadata.Size
+			fileInfo.Compression = metadata.Compression
+			fileInfo.StoredSize = metadata.StoredSize
+			fileInfo.StorageClass = metadata.StorageClass
+		}
+	}
+	return list, cursor.Err()
+}
+
+// Delete deletes one or all versions of the file and releases unreferenced content.
+func (m *MongoDB) Delete(name, version string, created ...time.Time) (released []*file.Meta, err error) {
+	filter := bson.M{"name": name}
+	if version != "" {
+		filter["version"] = version
+	}
+
+	if len(created) > 0 {
+		filter["created"] = created[0]
+	}
+
+	err = m.transaction(func(ctx mongo.SessionContext) (err error) {
+		if err = m.touchHead(ctx, name); err != nil {
+			return err
+		}
+		released, err = m.remove(ctx, filter)
+		return err
+	})
+	return released, err
+}
+
+// touchHead updates the head document of the name. The versions of a name are separate documents, so
+// concurrent writers that checked the store options against the same latest version would all insert
+// theirs: as each one also updates the head, all but the first conflict, and their transactions are
+// retried and check the options again against the version that was stored.
+func (m *MongoDB) touchHead(ctx mongo.SessionContext, name string) error {
+	_, err := m.heads.UpdateOne(ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"writes": 1}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// remove deletes the versions matching the filter and drops their references.
+// It returns the metadata of the content that is no longer referenced.
+func (m *MongoDB) remove(ctx mongo.SessionContext, filter bson.M) (released []*file.Meta, err error) {
+	cursor, err := m.files.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []file.Info
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	if len(docs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	if _, err = m.files.DeleteMany(ctx, filter); err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]int64)
+	contents := make(map[string]*file.Meta)
+	for i, doc := range docs {
+		refs[doc.Hash]++
+
+		metadata, found := contents[doc.Hash]
+		if !found {
+			metadata = &file.Meta{}
+			err = m.metadata.FindOne(ctx, bson.M{"hash": doc.Hash}).Decode(metadata)
+			if err == mongo.ErrNoDocuments {
+				continue
+
+			} else if err != nil {
+				return nil, err
+			}
+			contents[doc.Hash] = metadata
+		}
+
+		if err = m.charge(ctx, &docs[i], metadata, -1); err != nil {
+			return nil, err
+		}
+	}
+
+	for hash, n := range refs {
+		metadata, err := m.unref(ctx, hash, n)
+		if err != nil {
+			return nil, err
+		}
+
+		if metadata != nil {
+			released = append(released, metadata)
+		}
+	}
+	return released, nil
+}
+
+// unref drops n references of the content and deletes its metadata when nothing refers to it.
+// It returns the deleted metadata.
+func (m *MongoDB) unref(ctx mongo.SessionContext, hash string, n int64) (*file.Meta, error) {
+	var metadata file.Meta
+
+	err := m.metadata.FindOneAndUpdate(ctx,
+		bson.M{"hash": hash},
+		bson.M{"$inc": bson.M{"refs": -n}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&metadata)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+
+	} else if err != nil {
+		return nil, err
+	}
+
+	if metadata.Refs > 0 {
+		return nil, nil
+	}
+
+	if _, err = m.metadata.DeleteOne(ctx, bson.M{"hash": hash}); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// Expiring lists the versions whose expiry time is not after now.
+func (m *MongoDB) Expiring(now time.Time, limit int) ([]*file.Info, error) {
+	return m.find(bson.M{"expires": bson.M{"$lte": now}}, limit)
+}
+
+// Older lists the versions with the name prefix stored before the given time.
+func (m *MongoDB) Older(prefix string, before time.Time, limit int) ([]*file.Info, error) {
+	return m.find(bson.M{
+		"name":    bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+		"created": bson.M{"$lt": before},
+	}, limit)
+}
+
+// find lists up to limit versions matching the filter.
+func (m *MongoDB) find(filter bson.M, limit int) ([]*file.Info, error) {
+	ctx := context.Background()
+
+	cursor, err := m.files.Find(ctx, filter, options.Find().SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Info
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// StaleEncryption lists content metadata whose data key is wrapped with another master key.
+func (m *MongoDB) StaleEncryption(current, after string, limit int) ([]*file.Meta, error) {
+	ctx := context.Background()
+
+	filter := bson.M{
+		"hash":             bson.M{"$gt": after},
+		"encryption.keyId": bson.M{"$exists": true, "$nin": bson.A{current, ""}},
+	}
+	cursor, err := m.metadata.Find(ctx, filter, options.Find().SetSort(bson.M{"hash": 1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Meta
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// SetEncryption replaces the encryption description of the content.
+func (m *MongoDB) SetEncryption(hash string, enc *file.Encryption) error {
+	result, err := m.metadata.UpdateOne(context.Background(), bson.M{"hash": hash}, bson.M{"$set": bson.M{"encryption": enc}})
+	if err == nil && result.MatchedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// Names lists the names with a version referencing the content.
+func (m *MongoDB) Names(hash string) ([]string, error) {
+	values, err := m.files.Distinct(context.Background(), "name", bson.M{"hash": hash})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(values))
+	for _, value := range values {
+		if name, ok := value.(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// SegmentInUse reports whether any content metadata refers to the segment URL.
+func (m *MongoDB) SegmentInUse(url string) (bool, error) {
+	filter := bson.M{"$or": bson.A{bson.M{"metadata": url}, bson.M{"replicas": url}}}
+	count, err := m.metadata.CountDocuments(context.Background(), filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// Transitions lists content metadata the transition rule moves: the content of the matching versions
+// is looked up and filtered by the conditions of the rule on the content.
+func (m *MongoDB) Transitions(rule *file.Rule, now time.Time, limit int) ([]*file.Meta, error) {
+	ctx := context.Background()
+
+	conditions := bson.M{
+		"meta.storageClass": bson.M{"$ne": rule.StorageClass},
+		"meta.metadata.0":   bson.M{"$exists": true},
+	}
+	if rule.IdleDays > 0 {
+		conditions["$or"] = bson.A{
+			bson.M{"meta.accessed": bson.M{"$exists": false}},
+			bson.M{"meta.accessed": bson.M{"$lte": now.AddDate(0, 0, -rule.IdleDays)}},
+		}
+	}
+	if rule.MinReads > 0 {
+		conditions["meta.reads"] = bson.M{"$gte": rule.MinReads}
+	}
+
+	cursor, err := m.files.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"name":    bson.M{"$regex": "^" + regexp.QuoteMeta(rule.Prefix)},
+			"created": bson.M{"$lt": now.AddDate(0, 0, -rule.Days)},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$hash"}}},
+		{{Key: "$lookup", Value: bson.M{"from": metadataCollection, "localField": "_id", "foreignField": "hash", "as": "meta"}}},
+		{{Key: "$unwind", Value: "$meta"}},
+		{{Key: "$match", Value: conditions}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$meta"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Meta
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// MoveSegments replaces the segment and replica URLs of the content if they are still the old ones.
+func (m *MongoDB) MoveSegments(hash string, old *file.Meta, segments, replicas []string, class string) error {
+	result, err := m.metadata.UpdateOne(context.Background(),
+		segmentsFilter(hash, old),
+		bson.M{
+			"$set":   bson.M{"metadata": segments, "replicas": replicas, "storageClass": class},
+			"$unset": bson.M{"reads": ""},
+		},
+	)
+	if err == nil && result.MatchedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// SegmentsOn lists content metadata with segments on the storages: a regular expression
+// matches the segment URLs that start with the base URL of one of them.
+func (m *MongoDB) SegmentsOn(storages []string, after string, limit int) ([]*file.Meta, error) {
+	ctx := context.Background()
+
+	prefixes := make([]string, len(storages))
+	for i, storage := range storages {
+		prefixes[i] = regexp.QuoteMeta(storage + "/")
+	}
+
+	on := bson.M{"$regex": "^(" + strings.Join(prefixes, "|") + ")"}
+	filter := bson.M{
+		"hash": bson.M{"$gt": after},
+		"$or":  bson.A{bson.M{"metadata": on}, bson.M{"replicas": on}},
+	}
+	cursor, err := m.metadata.Find(ctx, filter, options.Find().SetSort(bson.M{"hash": 1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Meta
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// ReplaceSegments replaces the segment and replica URLs of the content if they are still the old ones.
+func (m *MongoDB) ReplaceSegments(hash string, old *file.Meta, segments, replicas []string) error {
+	result, err := m.metadata.UpdateOne(context.Background(),
+		segmentsFilter(hash, old),
+		bson.M{"$set": bson.M{"metadata": segments, "replicas": replicas}},
+	)
+	if err == nil && result.MatchedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// segmentsFilter matches the content if it has still the segment and replica URLs of old;
+// no replicas match content without the field.
+func segmentsFilter(hash string, old *file.Meta) bson.M {
+	filter := bson.M{"hash": hash, "metadata": old.Metadata, "replicas": nil}
+	if len(old.Replicas) > 0 {
+		filter["replicas"] = old.Replicas
+	}
+	return filter
+}
+
+// Touch counts reads of the content.
+func (m *MongoDB) Touch(hash string, reads int64, at time.Time) error {
+	result, err := m.metadata.UpdateOne(context.Background(),
+		bson.M{"hash": hash},
+		bson.M{
+			"$inc": bson.M{"reads": reads},
+			"$max": bson.M{"accessed": at},
+		},
+	)
+	if err == nil && result.MatchedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// Namespace loads the namespace settings.
+func (m *MongoDB) Namespace(name string) (*file.Namespace, error) {
+	ns := &file.Namespace{}
+
+	err := m.namespaces.FindOne(context.Background(), bson.M{"name": name}).Decode(ns)
+	if err == mongo.ErrNoDocuments {
+		return &file.Namespace{Name: name}, nil
+	}
+	return ns, err
+}
+
+// Namespaces lists the configured namespaces.
+func (m *MongoDB) Namespaces() ([]*file.Namespace, error) {
+	ctx := context.Background()
+
+	cursor, err := m.namespaces.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Namespace
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// SetNamespace stores the namespace settings.
+func (m *MongoDB) SetNamespace(ns *file.Namespace) error {
+	_, err := m.namespaces.ReplaceOne(context.Background(), bson.M{"name": ns.Name}, ns, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Rules lists the lifecycle rules.
+func (m *MongoDB) Rules() ([]*file.Rule, error) {
+	ctx := context.Background()
+
+	cursor, err := m.lifecycle.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Rule
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// SetRule stores the lifecycle rule.
+func (m *MongoDB) SetRule(rule *file.Rule) error {
+	_, err := m.lifecycle.ReplaceOne(context.Background(), bson.M{"id": rule.ID}, rule, options.Replace().SetUpsert(true))
+	return err
+}
+
+// DeleteRule deletes the lifecycle rule.
+func (m *MongoDB) DeleteRule(id string) error {
+	result, err := m.lifecycle.DeleteOne(context.Background(), bson.M{"id": id})
+	if err == nil && result.DeletedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// Policy loads the access policy of the principal.
+func (m *MongoDB) Policy(principal string) (*file.Policy, error) {
+	policy := &file.Policy{}
+
+	err := m.policies.FindOne(context.Background(), bson.M{"principal": principal}).Decode(policy)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	return policy, err
+}
+
+// Policies lists the access policies.
+func (m *MongoDB) Policies() ([]*file.Policy, error) {
+	ctx := context.Background()
+
+	cursor, err := m.policies.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"principal": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Policy
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// SetPolicy stores the access policy of its principal.
+func (m *MongoDB) SetPolicy(policy *file.Policy) error {
+	_, err := m.policies.ReplaceOne(context.Background(), bson.M{"principal": policy.Principal}, policy, options.Replace().SetUpsert(true))
+	return err
+}
+
+// DeletePolicy deletes the access policy of the principal.
+func (m *MongoDB) DeletePolicy(principal string) error {
+	result, err := m.policies.DeleteOne(context.Background(), bson.M{"principal": principal})
+	if err == nil && result.DeletedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// Usage returns the usage of the principal or namespace.
+func (m *MongoDB) Usage(scope, name string) (*file.Usage, error) {
+	usage := &file.Usage{}
+
+	err := m.usage.FindOne(context.Background(), bson.M{"scope": scope, "name": name}).Decode(usage)
+	if err == mongo.ErrNoDocuments {
+		return &file.Usage{Scope: scope, Name: name}, nil
+	}
+	return usage, err
+}
+
+// Usages lists the usage of the principals and namespaces.
+func (m *MongoDB) Usages() ([]*file.Usage, error) {
+	ctx := context.Background()
+
+	cursor, err := m.usage.Find(ctx, bson.M{"objects": bson.M{"$gt": 0}}, options.Find().SetSort(bson.D{{Key: "scope", Value: 1}, {Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Usage
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// Quota loads the quota of the principal or namespace.
+func (m *MongoDB) Quota(scope, name string) (*file.Quota, error) {
+	quota := &file.Quota{}
+
+	err := m.quotas.FindOne(context.Background(), bson.M{"scope": scope, "name": name}).Decode(quota)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	return quota, err
+}
+
+// Quotas lists the quotas.
+func (m *MongoDB) Quotas() ([]*file.Quota, error) {
+	ctx := context.Background()
+
+	cursor, err := m.quotas.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "scope", Value: 1}, {Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*file.Quota
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// SetQuota stores the quota of its principal or namespace.
+func (m *MongoDB) SetQuota(quota *file.Quota) error {
+	_, err := m.quotas.ReplaceOne(context.Background(),
+		bson.M{"scope": quota.Scope, "name": quota.Name}, quota, options.Replace().SetUpsert(true))
+	return err
+}
+
+// DeleteQuota deletes the quota of the principal or namespace.
+func (m *MongoDB) DeleteQuota(scope, name string) error {
+	result, err := m.quotas.DeleteOne(context.Background(), bson.M{"scope": scope, "name": name})
+	if err == nil && result.DeletedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// Close disconnects from the MongoDB.
+func (m *MongoDB) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.client.Disconnect(ctx)
+}
+
+// attach adds the content metadata to the file info.
+func (m *MongoDB) attach(fileInfo *file.Info) *file.Info {
+	if metadata, err := m.LoadMeta(fileInfo.Hash); err == nil {
+		fileInfo.Size = metadata.Size
+		fileInfo.Metadata = metadata.Metadata
+		fileInfo.Replicas = metadata.Replicas
+		fileInfo.Encryption = metadata.Encryption
+		fileInfo.Compression = metadata.Compression
+		fileInfo.StoredSize = metadata.StoredSize
+		fileInfo.SegmentSizes = metadata.SegmentSizes
+		fileInfo.StorageClass = metadata.StorageClass
+	}
+	return fileInfo
+}
+
+// latestFirst sorts the versions of a name from the newest one.
+func latestFirst() *options.FindOneOptions {
+	return options.FindOne().SetSort(bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}})
+}
+
+func notFound(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
 }
@@ -1,17 +1,243 @@
 package file
 
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
 // Info represents the file information.
 type Info struct {
-	Name     string   `json:"name"`
-	Hash     string   `json:"hash"`
-	Size     int64    `json:"size,omitempty"`
-	Metadata []string `json:"metadata,omitempty"`
+	Name    string     `json:"name"               bson:"name"`
+	Hash    string     `json:"hash"               bson:"hash"`
+	Version string     `json:"version,omitempty"  bson:"version"`
+	Created time.Time  `json:"created"            bson:"created"`
+	Latest  bool       `json:"latest,omitempty"   bson:"-"`
+	Expires *time.Time `json:"expires,omitempty"  bson:"expires,omitempty"`
+	Owner   string     `json:"owner,omitempty"    bson:"owner,omitempty"`
+
+	ContentType        string            `json:"contentType,omitempty"        bson:"contentType,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty" bson:"contentDisposition,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"    bson:"contentEncoding,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"       bson:"cacheControl,omitempty"`
+	UserMeta           map[string]string `json:"meta,omitempty"               bson:"meta,omitempty"`
+
+	Size       int64       `json:"size,omitempty"     bson:"size,omitempty"`
+	Metadata   []string    `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Replicas   []string    `json:"-"                  bson:"-"`
+	Encryption *Encryption `json:"-"                  bson:"-"`
+
+	Compression  string  `json:"compression,omitempty"  bson:"-"`
+	StoredSize   int64   `json:"storedSize,omitempty"   bson:"-"`
+	SegmentSizes []int64 `json:"-"                      bson:"-"`
+	StorageClass string  `json:"storageClass,omitempty" bson:"-"`
 }
 
-// Meta represents the file metadata.
+// Meta represents the file metadata. Size is the logical size of the content and
+// SegmentSizes the logical sizes of its segments, while StoredSize is what the
+// possibly compressed and encrypted segments take on the storages.
+// StorageClass is the class of the storages of the segments, empty for any class;
+// Reads counts the reads of the content since it moved there, the last one at Accessed.
+// Replicas are the URLs of the other copies of the segments, see Copies.
 type Meta struct {
-	Hash     string   `bson:"hash"`
-	Size     int64    `bson:"size"`
-	Metadata []string `bson:"metadata"`
+	Hash         string      `bson:"hash"`
+	Size         int64       `bson:"size"`
+	Metadata     []string    `bson:"metadata"`
+	Replicas     []string    `bson:"replicas,omitempty"`
+	Encryption   *Encryption `bson:"encryption,omitempty"`
+	Compression  string      `bson:"compression,omitempty"`
+	StoredSize   int64       `bson:"storedSize,omitempty"`
+	SegmentSizes []int64     `bson:"segmentSizes,omitempty"`
+	StorageClass string      `bson:"storageClass,omitempty"`
+	Reads        int64       `bson:"reads,omitempty"`
+	Accessed     *time.Time  `bson:"accessed,omitempty"`
+	Refs         int64       `bson:"refs"`
+}
+
+// Stored returns the bytes the content takes on the storages.
+// Content stored before it was tracked counts with its logical size.
+func (m *Meta) Stored() int64 {
+	if m.StoredSize > 0 {
+		return m.StoredSize
+	}
+	return m.Size
+}
+
+// Segments returns the URLs of the segments followed by the URLs of their replicas.
+func (m *Meta) Segments() []string {
+	return slices.Concat(m.Metadata, m.Replicas)
+}
+
+// Copies returns the URLs of the copies of the segment among the segment URLs and the replica URLs:
+// a segment is named by its hash on every storage, so the copies are the URLs with the same last element.
+func Copies(segmentURL string, replicas []string) []string {
+	hash := segmentURL[strings.LastIndex(segmentURL, "/")+1:]
+
+	copies := []string{segmentURL}
+	for _, replica := range replicas {
+		if strings.HasSuffix(replica, "/"+hash) {
+			copies = append(copies, replica)
+		}
+	}
+	return copies
 }
 
+// Encryption describes how the content is encrypted at rest: its data key is kept
+// wrapped with the master key with the key id or, if the key was provided by the
+// client, only the fingerprint of the key is kept.
+type Encryption struct {
+	Algorithm   string `bson:"algorithm"`
+	KeyID       string `bson:"keyId,omitempty"`
+	WrappedKey  []byte `bson:"wrappedKey,omitempty"`
+	Fingerprint string `bson:"fingerprint,omitempty"`
+}
+
+// Namespace holds the settings shared by all files of a namespace.
+type Namespace struct {
+	Name        string `json:"name"                  bson:"name"`
+	Versioning  bool   `json:"versioning"            bson:"versioning"`
+	Compression string `json:"compression,omitempty" bson:"compression,omitempty"`
+	Placement   string `json:"placement,omitempty"   bson:"placement,omitempty"`
+
+	// FailureDomain, if set, spreads the segments of a file over distinct zones, racks or hosts,
+	// at least MinDomains of them.
+	FailureDomain string `json:"failureDomain,omitempty" bson:"failureDomain,omitempty"`
+	MinDomains    int    `json:"minDomains,omitempty"    bson:"minDomains,omitempty"`
+
+	// StorageClass, if set, places the files on the storages of the class unless an upload requests another one.
+	StorageClass string `json:"storageClass,omitempty" bson:"storageClass,omitempty"`
+}
+
+// Rule is a lifecycle rule: the files whose names start with the prefix
+// are deleted the given number of days after they were stored.
+// A rule with a storage class moves their content to the storages of the class instead,
+// and only content not read for IdleDays or read MinReads times in its current class, if set.
+type Rule struct {
+	ID           string `json:"id"                     bson:"id"`
+	Prefix       string `json:"prefix"                 bson:"prefix"`
+	Days         int    `json:"days"                   bson:"days"`
+	StorageClass string `json:"storageClass,omitempty" bson:"storageClass,omitempty"`
+	IdleDays     int    `json:"idleDays,omitempty"     bson:"idleDays,omitempty"`
+	MinReads     int64  `json:"minReads,omitempty"     bson:"minReads,omitempty"`
+}
+
+// Moves reports whether the transition rule moves the content by now, whatever its versions are.
+// Content never read is idle since it was stored.
+func (r *Rule) Moves(m *Meta, now time.Time) bool {
+	switch {
+	case len(m.Metadata) == 0 || m.StorageClass == r.StorageClass:
+		return false
+	case r.IdleDays > 0 && m.Accessed != nil && m.Accessed.After(now.AddDate(0, 0, -r.IdleDays)):
+		return false
+	case r.MinReads > 0 && m.Reads < r.MinReads:
+		return false
+	}
+	return true
+}
+
+// Policy grants a principal access to the files. Admins may do everything,
+// including the cluster and maintenance endpoints.
+type Policy struct {
+	Principal string  `json:"principal"       bson:"principal"`
+	Admin     bool    `json:"admin,omitempty" bson:"admin,omitempty"`
+	Grants    []Grant `json:"grants"          bson:"grants"`
+}
+
+// Grant allows the actions on the files of the namespace whose names start with the prefix.
+// An empty namespace or prefix matches every file.
+type Grant struct {
+	Namespace string   `json:"namespace,omitempty" bson:"namespace,omitempty"`
+	Prefix    string   `json:"prefix,omitempty"    bson:"prefix,omitempty"`
+	Actions   []string `json:"actions"             bson:"actions"`
+}
+
+// Quota scopes.
+const (
+	ScopePrincipal = "principal"
+	ScopeNamespace = "namespace"
+)
+
+// Quota limits what a principal stores, or what is stored in a namespace. Zero limits are unlimited.
+type Quota struct {
+	Scope          string `json:"scope"                    bson:"scope"`
+	Name           string `json:"name"                     bson:"name"`
+	MaxObjects     int64  `json:"maxObjects,omitempty"     bson:"maxObjects,omitempty"`
+	MaxBytes       int64  `json:"maxBytes,omitempty"       bson:"maxBytes,omitempty"`
+	MaxStoredBytes int64  `json:"maxStoredBytes,omitempty" bson:"maxStoredBytes,omitempty"`
+}
+
+// Usage is what a principal stores, or what is stored in a namespace: every version counts as
+// an object with the logical and stored size of its content, even if the content is shared.
+type Usage struct {
+	Scope       string `json:"scope"       bson:"scope"`
+	Name        string `json:"name"        bson:"name"`
+	Objects     int64  `json:"objects"     bson:"objects"`
+	Bytes       int64  `json:"bytes"       bson:"bytes"`
+	StoredBytes int64  `json:"storedBytes" bson:"storedBytes"`
+}
+
+// Exceeded returns the limit of the quota the usage exceeds, empty if there is none.
+func (q *Quota) Exceeded(u *Usage) string {
+	switch {
+	case q.MaxObjects > 0 && u.Objects > q.MaxObjects:
+		return "objects"
+	case q.MaxBytes > 0 && u.Bytes > q.MaxBytes:
+		return "bytes"
+	case q.MaxStoredBytes > 0 && u.StoredBytes > q.MaxStoredBytes:
+		return "stored bytes"
+	}
+	return ""
+}
+
+// UsageScopes returns the scopes the version is accounted to: its namespace and, if it has one, its owner.
+func (i *Info) UsageScopes() [][2]string {
+	scopes := [][2]string{{ScopeNamespace, NamespaceOf(i.Name)}}
+	if i.Owner != "" {
+		scopes = append(scopes, [2]string{ScopePrincipal, i.Owner})
+	}
+	return scopes
+}
+
+// Actions granted by policies.
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+	ActionList   = "list"
+)
+
+// Allows reports whether the policy allows the action on the file name.
+func (p *Policy) Allows(action, name string) bool {
+	if p.Admin {
+		return true
+	}
+
+	for _, grant := range p.Grants {
+		if grant.Namespace != "" && grant.Namespace != NamespaceOf(name) {
+			continue
+		}
+		if strings.HasPrefix(name, grant.Prefix) && slices.Contains(grant.Actions, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the file has expired by now.
+func (i *Info) Expired(now time.Time) bool {
+	return i.Expires != nil && !i.Expires.After(now)
+}
+
+// NullVersion is the version of the files stored without versioning.
+const NullVersion = "null"
+
+// DefaultNamespace is the namespace of the names without a slash.
+const DefaultNamespace = "default"
+
+// NamespaceOf returns the namespace of the file name, which is the part before the first slash.
+func NamespaceOf(name string) string {
+	if i := strings.IndexByte(name, '/'); i > 0 {
+		return name[:i]
+	}
+	return DefaultNamespace
+}
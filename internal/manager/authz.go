@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"dcloud/internal/auth"
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+// policy returns the access policy of the principal of the request,
+// nil if the API is open. Principals without a policy get an empty one.
+func (m *Manager) policy(r *http.Request) (*file.Policy, error) {
+	principal := auth.FromContext(r.Context())
+	if principal == nil {
+		return nil, nil
+	}
+
+	if m.admins[principal.ID] {
+		return &file.Policy{Principal: principal.ID, Admin: true}, nil
+	}
+
+	policy, err := m.db.Policy(principal.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		return &file.Policy{Principal: principal.ID}, nil
+	}
+	return policy, err
+}
+
+// owner returns the principal the files written by the request belong to, empty if the API is open.
+func owner(r *http.Request) string {
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		return principal.ID
+	}
+	return ""
+}
+
+// authorize reports whether the principal of the request may do the action on the file name.
+// Otherwise it writes the error response.
+func (m *Manager) authorize(w http.ResponseWriter, r *http.Request, action, name string) bool {
+	policy, err := m.policy(r)
+	if err != nil {
+		log.Printf("Policy of %s: %v", auth.FromContext(r.Context()).ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if policy != nil && !policy.Allows(action, name) {
+		log.Printf("Access denied: %s may not %s %s", policy.Principal, action, name)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// adminOnly restricts the handler to the admins.
+func (m *Manager) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy, err := m.policy(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if policy != nil && !policy.Admin {
+			log.Printf("Access denied: %s is not an admin (%s %s)", policy.Principal, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"dcloud/internal/compress"
+	"dcloud/internal/file"
+)
+
+// compression returns the compression of the upload: the algorithm of the X-Compression header,
+// none to store the content as is, or else the compression of the namespace.
+func (m *Manager) compression(r *http.Request, filename string) (string, error) {
+	switch algorithm := r.Header.Get("X-Compression"); {
+	case algorithm == "none":
+		return "", nil
+
+	case algorithm != "":
+		if !compress.Valid(algorithm) {
+			return "", fmt.Errorf("%w %q", compress.ErrUnsupported, algorithm)
+		}
+		return algorithm, nil
+	}
+
+	ns, err := m.db.Namespace(file.NamespaceOf(filename))
+	if err != nil {
+		return "", err
+	}
+	return ns.Compression, nil
+}
+
+// spooledChunk is a compressed chunk in a temporary file, removed when it is closed.
+type spooledChunk struct {
+	*os.File
+}
+
+func (c spooledChunk) Close() error {
+	c.File.Close()
+	return os.Remove(c.Name())
+}
+
+// compressChunk compresses the chunk into a temporary file, since the storages need
+// the size of a segment before it is sent, and returns it with its compressed size.
+func compressChunk(algorithm string, chunk io.Reader) (io.ReadCloser, int64, error) {
+	tmp, err := os.CreateTemp("", "dcloud-chunk-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	spooled := spooledChunk{tmp}
+
+	writer, err := compress.NewWriter(algorithm, tmp)
+	if err == nil {
+		if _, err = io.Copy(writer, chunk); err == nil {
+			err = writer.Close()
+		}
+	}
+
+	var size int64
+	if err == nil {
+		if size, err = tmp.Seek(0, io.SeekCurrent); err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+	}
+
+	if err != nil {
+		spooled.Close()
+		return nil, 0, err
+	}
+	return spooled, size, nil
+}
@@ -1,43 +1,254 @@
 package manager
 
 import (
+	"crypto/rand"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"dcloud/internal/auth"
+	"dcloud/internal/certs"
+	"dcloud/internal/crypt"
 	"dcloud/internal/database"
+	"dcloud/internal/file"
 )
 
 const (
 	timeout    = 10 * time.Second
 	storedMark = "[STORED]"
+
+	lifecycleInterval = time.Minute
+	lifecycleBatch    = 1000
+	tieringInterval   = time.Minute
+	repairInterval    = time.Minute
 )
 
+// Config holds the manager settings.
+type Config struct {
+	Addr string
+
+	// MetaURI is the connection string of the metadata store, see database.Open.
+	MetaURI string
+
+	// APIKeysFile, HMACKeysFile and JWKSFile are the files of the authenticators, see the auth package.
+	// The API is open if none is set.
+	APIKeysFile  string
+	HMACKeysFile string
+	JWKSFile     string
+
+	// JWTIssuer and JWTAudience, if set, are required in the iss and aud claims of bearer tokens.
+	JWTIssuer   string
+	JWTAudience string
+
+	// ClusterSecret, if set, is shared with the storages: only storages signing their registration
+	// with it may join the cluster, and the requests of the manager to the storages are signed with it.
+	ClusterSecret string
+
+	// PresignSecret signs the presigned URLs. If it is not set, a random secret is used
+	// and the URLs minted before a restart are no longer valid.
+	PresignSecret string
+
+	// TLSCert and TLSKey, if set, are the files of the certificate the API is served with over TLS.
+	// They are reloaded when the files change.
+	TLSCert string
+	TLSKey  string
+
+	// StorageCA is the file of the CA certificates trusted for HTTPS storages;
+	// the system roots are used if it is not set.
+	StorageCA string
+
+	// KeyFile, if set, is the keyfile of the master keys: new content is encrypted at rest
+	// with data keys wrapped by the current master key, see crypt.Keyring.
+	KeyFile string
+
+	// Placement is the placement policy of the files of the namespaces without one, PlacementSpread if it is not set.
+	Placement string
+
+	// FailureDomain, if set, is the failure domain level the segments of a file are spread over,
+	// at least MinDomains of them, in the namespaces without one.
+	FailureDomain string
+	MinDomains    int
+
+	// Replicas is the number of copies of every segment kept on distinct storages, 1 if it is not set.
+	Replicas int
+
+	// StorageTimeout is how long a storage may miss its heartbeats before it is considered failed
+	// and its segments are repaired, 2 minutes if it is not set.
+	StorageTimeout time.Duration
+
+	// Admins are the principals with full access, whatever their policies are.
+	// The other principals are granted access by the policies stored in the metadata store.
+	Admins []string
+}
+
+// authenticator returns the chain of the configured authenticators, nil if there is none.
+func (c *Config) authenticator() (auth.Authenticator, error) {
+	var chain auth.Chain
+
+	if c.APIKeysFile != "" {
+		keys, err := auth.LoadAPIKeys(c.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("API keys: %w", err)
+		}
+		chain = append(chain, keys)
+	}
+
+	if c.HMACKeysFile != "" {
+		keys, err := auth.LoadHMACKeys(c.HMACKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("HMAC keys: %w", err)
+		}
+		chain = append(chain, keys)
+	}
+
+	if c.JWKSFile != "" {
+		jwks, err := auth.LoadJWKS(c.JWKSFile, c.JWTIssuer, c.JWTAudience)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS: %w", err)
+		}
+		chain = append(chain, jwks)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
 // New creates a new storage manager.
-func New(addr, mongodb string) (m *Manager, err error) {
+func New(cfg Config) (m *Manager, err error) {
 	m = &Manager{
-		storages: make(map[string]*Storage),
+		storages:       make(map[string]*Storage),
+		admins:         make(map[string]bool),
+		plans:          make(map[string]*uploadPlan),
+		reserved:       make(map[string]*file.Usage),
+		placements:     newPlacements(),
+		reads:          make(map[string]*readCount),
+		segmentLocks:   make(map[string]*segmentLock),
+		placement:      cfg.Placement,
+		storageTimeout: cfg.StorageTimeout,
+		replicas:       max(cfg.Replicas, 1),
+		clusterSecret:  []byte(cfg.ClusterSecret),
+	}
+
+	if m.storageTimeout <= 0 {
+		m.storageTimeout = 2 * time.Minute
+	}
+
+	if m.placement == "" {
+		m.placement = PlacementSpread
+	} else if !ValidPlacement(m.placement) {
+		return nil, fmt.Errorf("unknown placement policy %q", m.placement)
+	}
+
+	if !ValidDomain(cfg.FailureDomain) {
+		return nil, fmt.Errorf("unknown failure domain %q", cfg.FailureDomain)
+	}
+	m.spreading = spreading{level: cfg.FailureDomain, min: cfg.MinDomains}
+
+	for _, admin := range cfg.Admins {
+		m.admins[admin] = true
 	}
 
-	m.mongodb, err = database.Connect(mongodb)
+	m.presignSecret = []byte(cfg.PresignSecret)
+	if len(m.presignSecret) == 0 {
+		m.presignSecret = make([]byte, 32)
+		rand.Read(m.presignSecret)
+	}
+
+	authenticator, err := cfg.authenticator()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.client, err = certs.Client(cfg.StorageCA, timeout); err != nil {
+		return nil, fmt.Errorf("storage CA: %w", err)
+	}
+
+	if cfg.KeyFile != "" {
+		if m.keyring, err = crypt.LoadKeyring(cfg.KeyFile); err != nil {
+			return nil, fmt.Errorf("keyfile: %w", err)
+		}
+	}
+
+	m.db, err = database.Open(cfg.MetaURI)
 	if err != nil {
 		return nil, err
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", m.routeHandler)
-	mux.HandleFunc("/register", m.storageRegister)
-	mux.HandleFunc("/usage", m.storageUsage)
+	mux.HandleFunc("/presign", m.presignHandler)
+	mux.HandleFunc("/usage", m.adminOnly(m.storageUsage))
+	mux.HandleFunc("/namespaces", m.adminOnly(m.namespaceHandler))
+	mux.HandleFunc("/namespaces/", m.adminOnly(m.namespaceHandler))
+	mux.HandleFunc("/lifecycle", m.adminOnly(m.lifecycleHandler))
+	mux.HandleFunc("/lifecycle/", m.adminOnly(m.lifecycleHandler))
+	mux.HandleFunc("/policies", m.adminOnly(m.policyHandler))
+	mux.HandleFunc("/policies/", m.adminOnly(m.policyHandler))
+	mux.HandleFunc("/quotas", m.adminOnly(m.quotaHandler))
+	mux.HandleFunc("/quotas/", m.adminOnly(m.quotaHandler))
+	mux.HandleFunc("/keys/rotate", m.adminOnly(m.rotateHandler))
+	mux.HandleFunc("/repair", m.adminOnly(m.repairHandler))
+	mux.HandleFunc("/drain", m.adminOnly(m.drainHandler))
+
+	var handler http.Handler = mux
+	if authenticator != nil {
+		authenticated := auth.Middleware(authenticator, mux)
+
+		// presigned URLs carry no credentials, routeHandler verifies their signature
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isPresigned(r) {
+				m.routeHandler(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	} else {
+		log.Printf("No authentication configured, the API is open")
+	}
+
+	// with a cluster secret the storages register with their signature instead of user credentials
+	if cfg.ClusterSecret != "" {
+		root := http.NewServeMux()
+		root.Handle("/", handler)
+		root.Handle("/register", auth.Middleware(
+			auth.NewHMACKeys(map[string]string{auth.StoragePrincipal: cfg.ClusterSecret}).RejectReplays(),
+			http.HandlerFunc(m.storageRegister),
+		))
+		handler = root
+	} else {
+		mux.HandleFunc("/register", m.adminOnly(m.storageRegister))
+	}
 
 	m.server = &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Addr:    cfg.Addr,
+		Handler: handler,
+	}
+
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		reloader, err := certs.NewReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("TLS certificate: %w", err)
+		}
+		m.server.TLSConfig = reloader.TLSConfig()
 	}
 	return m, nil
 }
 
-// Start starts http server.
+// Start starts the background jobs and the http server.
 func (m *Manager) Start() {
+	go m.lifecycle()
+	go m.tiering()
+	go m.repairs()
+
+	if m.server.TLSConfig != nil {
+		log.Printf("Manager listening on %s (TLS)\n", m.server.Addr)
+		log.Print(m.server.ListenAndServeTLS("", ""))
+		return
+	}
+
 	log.Printf("Manager listening on %s\n", m.server.Addr)
 	m.server.ListenAndServe()
 }
@@ -1,36 +1,32 @@
 package manager
 
 import (
+	"dcloud/internal/database"
 	"dcloud/internal/file"
 	"log"
 	"strings"
 )
 
 // Store stores the file info in files and metadata collections.
-func (m *Manager) Store(filename string, fileInfo any) {
-	var err error
-
-	switch val := fileInfo.(type) {
-	case string:
-		fileInfo := &file.Info{
-			Hash: val,
-			Name: filename,
-		}
-		err = m.mongodb.Store(fileInfo)
-
-	case *file.Info:
-		for i := range val.Metadata {
-			val.Metadata[i] = strings.Replace(val.Metadata[i], "upload", storedMark, 1)
-		}
-		err = m.mongodb.Store(val)
+// The content released by an overwrite is removed from the storages in the background.
+func (m *Manager) Store(fileInfo *file.Info, opts database.StoreOptions) error {
+	for i := range fileInfo.Metadata {
+		fileInfo.Metadata[i] = strings.Replace(fileInfo.Metadata[i], "upload", storedMark, 1)
 	}
 
+	released, err := m.db.Store(fileInfo, opts)
 	if err != nil {
-		log.Printf("Failed to insert metadata into MongoDB: %v\n", err)
+		log.Printf("Failed to insert metadata: %v\n", err)
+		return err
+	}
+
+	if len(released) > 0 {
+		go m.reclaim(released)
 	}
+	return nil
 }
 
-// Find finds the file info from the MongoDB.
+// Load finds the file info in the metadata store.
 func (m *Manager) Load(filename string, hash ...string) (*file.Info, error) {
-	return m.mongodb.Load(filename, hash...)
+	return m.db.Load(filename, hash...)
 }
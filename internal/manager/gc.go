@@ -0,0 +1,138 @@
+package manager
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"dcloud/internal/file"
+)
+
+// segmentLock serializes the commits of a segment URL with its deletion: the same content placed
+// on the same storage again is committed to the same URL, maybe while its old segment is reclaimed.
+type segmentLock struct {
+	sync.Mutex
+
+	// users are the goroutines holding or waiting for the lock and held the commits of the URL
+	// not written to the metadata store yet, both guarded by segmentsLock
+	users int
+	held  int
+}
+
+// lockSegment locks the segment URL and returns its unlock function.
+func (m *Manager) lockSegment(segmentURL string) func() {
+	m.segmentsLock.Lock()
+	lock, found := m.segmentLocks[segmentURL]
+	if !found {
+		lock = &segmentLock{}
+		m.segmentLocks[segmentURL] = lock
+	}
+	lock.users++
+	m.segmentsLock.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		m.segmentsLock.Lock()
+		defer m.segmentsLock.Unlock()
+		lock.users--
+		m.forgetSegmentLock(segmentURL, lock)
+	}
+}
+
+// holdSegment keeps the segment of the target, committed under the lock of its URL,
+// from being reclaimed until it is released.
+func (m *Manager) holdSegment(target *Scheme) {
+	m.segmentsLock.Lock()
+	defer m.segmentsLock.Unlock()
+
+	m.segmentLocks[target.URL].held++
+	target.held = true
+}
+
+// releaseSegments releases the held segments of the scheme once their URLs are written to the
+// metadata store, or before they are deleted.
+func (m *Manager) releaseSegments(scheme []*Scheme) {
+	m.segmentsLock.Lock()
+	defer m.segmentsLock.Unlock()
+
+	for _, target := range scheme {
+		if !target.held {
+			continue
+		}
+		lock := m.segmentLocks[target.URL]
+		lock.held--
+		target.held = false
+		m.forgetSegmentLock(target.URL, lock)
+	}
+}
+
+// segmentHeld reports whether a commit of the segment URL is not written to the metadata store yet.
+func (m *Manager) segmentHeld(segmentURL string) bool {
+	m.segmentsLock.Lock()
+	defer m.segmentsLock.Unlock()
+
+	lock, found := m.segmentLocks[segmentURL]
+	return found && lock.held > 0
+}
+
+// forgetSegmentLock removes the lock of the segment URL once it is unused, with segmentsLock held.
+func (m *Manager) forgetSegmentLock(segmentURL string, lock *segmentLock) {
+	if lock.users == 0 && lock.held == 0 {
+		delete(m.segmentLocks, segmentURL)
+	}
+}
+
+// reclaim removes the segments and replicas of the released content from the storages.
+// A segment is kept while other content refers to the same segment URL or a commit of it
+// is not written to the metadata store yet; its deletion is serialized with its commits.
+func (m *Manager) reclaim(released []*file.Meta) {
+	for _, metadata := range released {
+		for _, segmentURL := range metadata.Segments() {
+			m.reclaimSegment(segmentURL)
+		}
+	}
+}
+
+// reclaimSegment deletes the segment unless it is in use.
+func (m *Manager) reclaimSegment(segmentURL string) {
+	unlock := m.lockSegment(segmentURL)
+	defer unlock()
+
+	if m.segmentHeld(segmentURL) {
+		return
+	}
+
+	inUse, err := m.db.SegmentInUse(segmentURL)
+	if err != nil {
+		log.Printf("reclaim: %s: %v", segmentURL, err)
+		return
+	}
+
+	if inUse {
+		return
+	}
+
+	url := strings.Replace(segmentURL, storedMark, "delete", 1)
+	done := m.changingStorage(url)
+	defer done()
+
+	resp, err := m.storageRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		log.Printf("reclaim: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("reclaim: %s status: %s", url, resp.Status)
+		return
+	}
+
+	size, _ := strconv.Atoi(resp.Header.Get("X-Size"))
+	m.updateStorage(url, -size, 0, -1)
+	log.Printf("Deleted chunk: %s (%v)", url, size)
+}
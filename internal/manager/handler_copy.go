@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"log"
+	"net/http"
+
+	"dcloud/internal/file"
+)
+
+// copyHandler copies the file, or its version given by versionId, to the name given by the copy
+// parameter, or renames it to the name given by the rename parameter. Only the names and the
+// reference counts change, the content stays on the storages. The destination is written with
+// the same overwrite rules and preconditions as an upload.
+func (m *Manager) copyHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	rename := query.Has("rename")
+
+	target := query.Get("copy")
+	if rename {
+		target = query.Get("rename")
+	}
+
+	dstName := cleanName(target)
+	if dstName == "" || dstName == objectName(r) {
+		http.Error(w, "Invalid destination name", http.StatusBadRequest)
+		return
+	}
+
+	if !m.authorize(w, r, file.ActionRead, objectName(r)) || !m.authorize(w, r, file.ActionWrite, dstName) ||
+		rename && !m.authorize(w, r, file.ActionDelete, objectName(r)) {
+		return
+	}
+
+	src, err := m.loadRequested(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// a file encrypted with a customer key is only copied or renamed by those who have the key
+	if _, err = m.dataKey(r, src); err != nil {
+		log.Printf("Data key of %s: %v", src.Name, err)
+		http.Error(w, keyError(err), keyStatus(err))
+		return
+	}
+
+	opts, err := m.storeOptions(dstName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conditions(r, &opts)
+
+	// a rename frees the source, which is not versioned: its older versions would be left behind
+	// or, without them, its history would be lost
+	var freed []*file.Info
+	if rename {
+		srcOpts, err := m.storeOptions(src.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if srcOpts.Versioned {
+			http.Error(w, "Rename in a versioned namespace", http.StatusConflict)
+			return
+		}
+		freed = append(freed, src)
+	}
+
+	releaseQuota, err := m.reserveQuota(owner(r), dstName, src.Size, opts, freed...)
+	if err != nil {
+		http.Error(w, err.Error(), storeStatus(err))
+		return
+	}
+	defer releaseQuota()
+
+	// the destination keeps the attributes of the source and belongs to the principal writing it
+	dst := *src
+	dst.Name = dstName
+	dst.Metadata = nil
+	dst.Replicas = nil
+	dst.Owner = owner(r)
+
+	if rename {
+		var released []*file.Meta
+		if released, err = m.db.Move(src, &dst, opts); err == nil && len(released) > 0 {
+			go m.reclaim(released)
+		}
+	} else {
+		err = m.Store(&dst, opts)
+	}
+
+	if err != nil {
+		log.Printf("Copy %s to %s: %v", src.Name, dstName, err)
+		http.Error(w, err.Error(), storeStatus(err))
+		return
+	}
+
+	log.Printf("filename: %s version: %s copied to %s version: %s (rename: %v)", src.Name, src.Version, dst.Name, dst.Version, rename)
+	setVersionHeaders(w, &dst)
+	dst.Latest = true
+	writeJSON(w, &dst)
+}
@@ -0,0 +1,369 @@
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"dcloud/internal/auth"
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+// planExpiry is how long the segment URLs of a transfer plan are valid.
+const planExpiry = 15 * time.Minute
+
+// Plan lists the segment URLs a client transfers directly to or from the storages.
+type Plan struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Version  string         `json:"version,omitempty"`
+	Hash     string         `json:"hash,omitempty"`
+	Size     int64          `json:"size"`
+	Expires  time.Time      `json:"expires"`
+	Segments []*PlanSegment `json:"segments"`
+}
+
+// PlanSegment is a segment of a plan. The hash is set for downloads only.
+type PlanSegment struct {
+	Index int    `json:"index"`
+	URL   string `json:"url"`
+	Size  int    `json:"size,omitempty"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// Receipt is what a storage answered to the direct upload of a segment:
+// the X-Hash, X-Filename and X-Receipt headers.
+type Receipt struct {
+	Hash     string `json:"hash"`
+	Filename string `json:"filename"`
+	Receipt  string `json:"receipt"`
+}
+
+// uploadPlan is a direct upload waiting for its receipts.
+type uploadPlan struct {
+	fileInfo  *file.Info
+	opts      database.StoreOptions
+	scheme    []*Scheme
+	tokens    []string
+	principal string
+	expires   time.Time
+
+	// releaseQuota releases the quota reserved for the upload
+	releaseQuota func()
+}
+
+// downloadPlanHandler returns the signed segment URLs of the file, so the client
+// downloads the segments from the storages and concatenates them itself.
+func (m *Manager) downloadPlanHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.directEnabled(w) || !m.authorize(w, r, file.ActionRead, objectName(r)) {
+		return
+	}
+
+	fileInfo, err := m.loadRequested(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if fileInfo.Encryption != nil {
+		http.Error(w, "Encrypted files are only served by the manager", http.StatusConflict)
+		return
+	}
+
+	if fileInfo.Compression != "" {
+		http.Error(w, "Compressed files are only served by the manager", http.StatusConflict)
+		return
+	}
+
+	plan := &Plan{
+		Name:     fileInfo.Name,
+		Version:  fileInfo.Version,
+		Hash:     fileInfo.Hash,
+		Size:     fileInfo.Size,
+		Expires:  time.Now().Add(planExpiry).Truncate(time.Second),
+		Segments: make([]*PlanSegment, len(fileInfo.Metadata)),
+	}
+
+	for i, segmentURL := range fileInfo.Metadata {
+		// the copy on the healthiest storage is handed out
+		segmentURL = m.readable(file.Copies(segmentURL, fileInfo.Replicas))[0]
+		signed, err := auth.SignURL(m.clusterSecret, http.MethodGet, strings.Replace(segmentURL, storedMark, "download", 1), 0, plan.Expires)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plan.Segments[i] = &PlanSegment{Index: i, URL: signed, Hash: filepath.Base(segmentURL)}
+	}
+	m.recordRead(fileInfo.Hash)
+	writeJSON(w, plan)
+}
+
+// uploadPlanHandler plans a direct upload of X-Size bytes: it reserves the space and returns
+// the signed segment URLs. The client uploads each segment with PUT and completes the
+// upload with the receipts of the storages. The request takes the same headers as an upload.
+func (m *Manager) uploadPlanHandler(w http.ResponseWriter, r *http.Request) {
+	filename := objectName(r)
+	if filename == "" {
+		http.Error(w, "filename is required", http.StatusBadRequest)
+		return
+	}
+
+	if !m.directEnabled(w) || !m.authorize(w, r, file.ActionWrite, filename) {
+		return
+	}
+
+	// the content of a direct upload never passes the manager to be encrypted
+	if m.keyring != nil || r.Header.Get("X-Encryption-Key") != "" {
+		http.Error(w, "Direct uploads are not available with server-side encryption", http.StatusNotImplemented)
+		return
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("X-Size"), 10, 64)
+	if err != nil || size <= 0 {
+		http.Error(w, "Invalid X-Size", http.StatusBadRequest)
+		return
+	}
+
+	opts, err := m.storeOptions(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conditions(r, &opts)
+
+	// fail early, the options are checked again when the upload completes
+	latest, err := m.Load(filename)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = opts.Check(latest); err != nil {
+		http.Error(w, err.Error(), storeStatus(err))
+		return
+	}
+
+	fileInfo := &file.Info{Name: filename, Owner: owner(r)}
+	if fileInfo.Expires, err = expiry(r, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = readAttributes(r, fileInfo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fileInfo.StorageClass, err = m.storageClass(r, filename); err != nil {
+		http.Error(w, err.Error(), classStatus(err))
+		return
+	}
+
+	// the quota stays reserved until the upload completes or its plan expires
+	releaseQuota, err := m.reserveQuota(fileInfo.Owner, filename, size, opts)
+	if err != nil {
+		http.Error(w, err.Error(), storeStatus(err))
+		return
+	}
+
+	scheme, err := m.uploadScheme(filename, fileInfo.StorageClass, int(size))
+	if err != nil {
+		log.Print(err)
+		releaseQuota()
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
+	pending := &uploadPlan{
+		fileInfo:     fileInfo,
+		opts:         opts,
+		scheme:       scheme,
+		tokens:       make([]string, len(scheme)),
+		expires:      time.Now().Add(planExpiry).Truncate(time.Second),
+		releaseQuota: releaseQuota,
+	}
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		pending.principal = principal.ID
+	}
+
+	plan := &Plan{
+		ID:       newPlanID(),
+		Name:     filename,
+		Size:     size,
+		Expires:  pending.expires,
+		Segments: make([]*PlanSegment, len(scheme)),
+	}
+
+	// the plan and the index of the segment in the signed path make each URL good for a single upload
+	for i, target := range scheme {
+		segmentURL := fmt.Sprintf("%s/segment/%s-%d", target.URL, plan.ID, i)
+		signed, err := auth.SignURL(m.clusterSecret, http.MethodPut, segmentURL, int64(target.Size), pending.expires)
+		if err != nil {
+			m.releaseScheme(scheme)
+			releaseQuota()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		u, _ := url.Parse(signed)
+		pending.tokens[i] = u.Query().Get(auth.TokenParam)
+		plan.Segments[i] = &PlanSegment{Index: i, URL: signed, Size: target.Size}
+	}
+
+	m.Lock()
+	m.plans[plan.ID] = pending
+	m.Unlock()
+
+	log.Printf("Planned direct upload %s of %s (%d bytes, %d segments)", plan.ID, filename, size, len(scheme))
+	writeJSON(w, plan)
+}
+
+// completeHandler completes the direct upload given by the complete parameter
+// with the receipts of its segments, in the order of the plan.
+func (m *Manager) completeHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("complete")
+
+	var receipts []*Receipt
+	if err := json.NewDecoder(r.Body).Decode(&receipts); err != nil {
+		http.Error(w, "Invalid receipts: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var principal string
+	if p := auth.FromContext(r.Context()); p != nil {
+		principal = p.ID
+	}
+
+	m.Lock()
+	pending, found := m.plans[id]
+	if found && pending.fileInfo.Name == objectName(r) && pending.principal == principal {
+		delete(m.plans, id)
+	} else {
+		found = false
+	}
+	m.Unlock()
+
+	if found {
+		defer pending.releaseQuota()
+	}
+
+	if !found || time.Now().After(pending.expires) {
+		if found {
+			m.releaseScheme(pending.scheme)
+		}
+		http.Error(w, "Unknown or expired plan", http.StatusNotFound)
+		return
+	}
+
+	scheme := pending.scheme
+	if err := m.verifyReceipts(pending, receipts); err != nil {
+		log.Printf("Direct upload %s of %s: %v", id, pending.fileInfo.Name, err)
+		// the segments with a valid receipt are rolled back, the space of the others is released
+		for i, target := range scheme {
+			if i < len(receipts) && m.validReceipt(pending, i, receipts[i]) {
+				target.Tmpfile = receipts[i].Filename
+			}
+		}
+		go m.rollbackScheme(scheme)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the storages hashed the segments, so the file hash is derived from theirs
+	hasher := sha256.New()
+	for i, target := range scheme {
+		target.Tmpfile = receipts[i].Filename
+		target.URL += "/" + storedMark + "/" + receipts[i].Hash
+		hasher.Write([]byte(receipts[i].Hash))
+	}
+
+	fileInfo := pending.fileInfo
+	fileInfo.Hash = fmt.Sprintf("%s-%d", hex.EncodeToString(hasher.Sum(nil)), len(scheme))
+	fileInfo.SegmentSizes = make([]int64, len(scheme))
+	for i, target := range scheme {
+		fileInfo.SegmentSizes[i] = int64(target.Size)
+		fileInfo.Size += int64(target.Size)
+	}
+	fileInfo.StoredSize = fileInfo.Size
+
+	if err := m.storeScheme(fileInfo, pending.opts, scheme); err != nil {
+		http.Error(w, err.Error(), storeStatus(err))
+		return
+	}
+	setVersionHeaders(w, fileInfo)
+
+	log.Printf("filename: %s size: %v hash: %v uploaded directly", fileInfo.Name, fileInfo.Size, fileInfo.Hash)
+}
+
+// verifyReceipts checks that every segment of the plan was uploaded with its token.
+func (m *Manager) verifyReceipts(pending *uploadPlan, receipts []*Receipt) error {
+	if len(receipts) != len(pending.scheme) {
+		return fmt.Errorf("%d receipts for %d segments", len(receipts), len(pending.scheme))
+	}
+
+	for i, receipt := range receipts {
+		if !m.validReceipt(pending, i, receipt) {
+			return fmt.Errorf("invalid receipt of segment %d", i)
+		}
+	}
+	return nil
+}
+
+// validReceipt reports whether the receipt was given by the storage for the segment i of the plan:
+// the token of the segment is only valid on that storage, so no other one gives a receipt for it.
+func (m *Manager) validReceipt(pending *uploadPlan, i int, receipt *Receipt) bool {
+	expected := auth.Receipt(m.clusterSecret, pending.tokens[i], receipt.Hash, receipt.Filename, int64(pending.scheme[i].Size))
+	return hmac.Equal([]byte(receipt.Receipt), []byte(expected))
+}
+
+// expirePlans drops the direct uploads that were not completed in time and releases their space.
+// The storages remove their temporary segments once the segment URLs have expired.
+func (m *Manager) expirePlans(now time.Time) {
+	m.Lock()
+	var expired []*uploadPlan
+	for id, pending := range m.plans {
+		if now.After(pending.expires) {
+			log.Printf("Direct upload %s of %s expired", id, pending.fileInfo.Name)
+			expired = append(expired, pending)
+			delete(m.plans, id)
+		}
+	}
+	m.Unlock()
+
+	for _, pending := range expired {
+		m.releaseScheme(pending.scheme)
+		pending.releaseQuota()
+	}
+}
+
+// releaseScheme releases the space reserved for the scheme.
+func (m *Manager) releaseScheme(scheme []*Scheme) {
+	for _, target := range scheme {
+		m.releaseSegment(target)
+	}
+}
+
+// directEnabled reports whether direct transfers are possible, which requires the
+// cluster secret to sign the segment URLs. Otherwise it writes the error response.
+func (m *Manager) directEnabled(w http.ResponseWriter) bool {
+	if len(m.clusterSecret) == 0 {
+		http.Error(w, "Direct transfers require a cluster secret", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+func newPlanID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -1,32 +1,88 @@
 package manager
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"dcloud/internal/file"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
-	"go.mongodb.org/mongo-driver/mongo"
+	"dcloud/internal/compress"
+	"dcloud/internal/crypt"
+	"dcloud/internal/database"
 )
 
-var ErrAlreadyExist = errors.New("File already exists")
+var (
+	ErrAlreadyExist = errors.New("File already exists")
+	ErrKeyRequired  = errors.New("The file is encrypted with a customer key")
+	ErrKeyMismatch  = errors.New("The customer key does not match the key of the file")
+)
 
 // routeHandler handles the incoming requests and routes them to the appropriate handler.
 func (m *Manager) routeHandler(w http.ResponseWriter, r *http.Request) {
+	if isPresigned(r) {
+		var ok bool
+		if r, ok = m.verifyPresigned(w, r); !ok {
+			return
+		}
+	}
+
 	switch r.Method {
 	case http.MethodGet:
+		if strings.HasSuffix(r.URL.Path, "/") {
+			m.listHandler(w, r)
+			return
+		}
+
+		if r.URL.Query().Has("stat") {
+			m.statHandler(w, r)
+			return
+		}
+
+		if r.URL.Query().Has("plan") {
+			m.downloadPlanHandler(w, r)
+			return
+		}
 		m.downloadHandler(w, r)
 
+	case http.MethodHead:
+		m.headHandler(w, r)
+
 	case http.MethodPut:
 		m.uploadHandler(w, r)
 
+	case http.MethodDelete:
+		m.deleteHandler(w, r)
+
+	case http.MethodPost:
+		switch query := r.URL.Query(); {
+		case query.Has("restore"):
+			m.restoreHandler(w, r)
+
+		case query.Has("copy"), query.Has("rename"):
+			m.copyHandler(w, r)
+
+		case query.Has("plan"):
+			m.uploadPlanHandler(w, r)
+
+		case query.Has("complete"):
+			m.completeHandler(w, r)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -39,7 +95,7 @@ func (m *Manager) uploadHandler(w http.ResponseWriter, r *http.Request) {
 		err error
 	)
 
-	filename := filepath.Base(r.URL.Path)
+	filename := objectName(r)
 	log.Printf("Received upload request for file: %s", filename)
 
 	rollback := false
@@ -51,6 +107,23 @@ func (m *Manager) uploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	if filename == "" {
+		http.Error(w, "filename is required", http.StatusBadRequest)
+		return
+	}
+
+	if !m.authorize(w, r, file.ActionWrite, filename) {
+		return
+	}
+
+	opts, err := m.storeOptions(filename)
+	if err != nil {
+		log.Printf("Namespace of %s: %v", filename, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conditions(r, &opts)
+
 	size := r.ContentLength
 	if size <= 0 {
 		log.Printf("Invalid Content-Length: %v", size)
@@ -58,135 +131,666 @@ func (m *Manager) uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hash := r.Header.Get("X-Hash")
+	fileInfo := &file.Info{
+		Name:  filename,
+		Hash:  r.Header.Get("X-Hash"),
+		Owner: owner(r),
+	}
 
-	if err = m.validateRequest(filename, hash); err == nil {
+	if fileInfo.Expires, err = expiry(r, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
+	}
 
-	} else if err != mongo.ErrNoDocuments {
+	if err = readAttributes(r, fileInfo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if fileInfo.Compression, err = m.compression(r, filename); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, compress.ErrUnsupported) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if fileInfo.StorageClass, err = m.storageClass(r, filename); err != nil {
+		http.Error(w, err.Error(), classStatus(err))
+		return
+	}
+
+	customerKey, err := customerKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if customerKey != nil {
+		// content under a customer key is never linked by its plain hash
+		fileInfo.Hash = ""
+	}
+
+	// the quotas are checked before the content is linked or space is reserved on the storages
+	releaseQuota, err := m.reserveQuota(fileInfo.Owner, filename, size, opts)
+	if err != nil {
+		log.Printf("Upload of %s: %v", filename, err)
+		http.Error(w, err.Error(), storeStatus(err))
+		return
+	}
+	defer releaseQuota()
+
+	policy, err := m.policy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = m.validateRequest(fileInfo, opts, policy); err == nil {
+		setVersionHeaders(w, fileInfo)
+		return
+
+	} else if !errors.Is(err, database.ErrNotFound) {
 		log.Printf("validateRequest for %s: %v", filename, err)
-		http.Error(w, err.Error(), http.StatusForbidden)
+		http.Error(w, err.Error(), storeStatus(err))
 		return
 	}
 
-	scheme, err = m.uploadScheme(int(size))
+	scheme, err = m.uploadScheme(filename, fileInfo.StorageClass, int(size))
 	if err != nil {
 		log.Print(err)
-		w.WriteHeader(http.StatusInsufficientStorage)
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
 		return
 	}
 
-	hasher := sha256.New()
-	var metadata []string
+	// the content is encrypted with the customer key if there is one; otherwise,
+	// with server-side encryption, it gets its own data key
+	var (
+		dataKey []byte
+		hasher  = sha256.New()
+	)
+	switch {
+	case customerKey != nil:
+		if dataKey, fileInfo.Encryption, err = crypt.NewCustomerDataKey(customerKey); err != nil {
+			log.Printf("Data key for %s: %v", filename, err)
+			rollback = true
+			return
+		}
+		// the hash is keyed, so it reveals nothing of the content and only the same key deduplicates it
+		hasher = hmac.New(sha256.New, customerKey)
+	case m.keyring != nil:
+		if dataKey, fileInfo.Encryption, err = m.keyring.NewDataKey(); err != nil {
+			log.Printf("Data key for %s: %v", filename, err)
+			rollback = true
+			return
+		}
+	}
 
-	for _, target := range scheme {
-		limitedReader := &io.LimitedReader{R: r.Body, N: int64(target.Size)}
+	fileInfo.SegmentSizes = make([]int64, len(scheme))
 
-		storedHash, tmpFilePath, err := m.storeChunk(w, target, hasher, limitedReader)
+	for i, target := range scheme {
+		fileInfo.SegmentSizes[i] = int64(target.Size)
+
+		chunk := io.TeeReader(&io.LimitedReader{R: r.Body, N: int64(target.Size)}, hasher)
+		chunkSize, err := m.uploadSegment(w, fileInfo, dataKey, i, target, chunk)
 		if err != nil {
-			log.Printf("Error storing chunk: %v", err)
 			rollback = true
 			return
 		}
 
-		target.Tmpfile = tmpFilePath                      // temporary filename on the storage side
-		target.URL += "/" + storedMark + "/" + storedHash // url based on hash
-		metadata = append(metadata, target.URL)
+		m.resizeSegment(target, int(chunkSize))
+		fileInfo.StoredSize += chunkSize
 	}
 
 	for i, target := range scheme {
 		log.Printf("Scheme[%d]: %v (%v)", i, target.URL, target.Size)
 	}
 
-	hash = hex.EncodeToString(hasher.Sum(nil))
+	fileInfo.Hash = hex.EncodeToString(hasher.Sum(nil))
+	fileInfo.Size = size
 
-	if _, err := m.Load(filename, hash); err == nil {
-		log.Printf("File with hash '%s' already exist. STORE & ROLLBACK", hash)
-		m.Store(filename, hash)
-		rollback = true
+	if err = m.storeScheme(fileInfo, opts, scheme); err != nil {
+		http.Error(w, err.Error(), storeStatus(err))
 		return
 	}
+	setVersionHeaders(w, fileInfo)
+
+	log.Printf("filename: %s size: %v sha256: %v uploaded successfully", filename, size, fileInfo.Hash)
+
+	prettyJSON, _ := json.MarshalIndent(fileInfo, "", "    ")
+	log.Print(string(prettyJSON))
+}
+
+// uploadSegment stores the segment i of the file read from chunk to the target of the scheme, compressed
+// and encrypted as the file is, and returns the bytes stored. The target gets the temporary name and the
+// URL of the segment.
+func (m *Manager) uploadSegment(w http.ResponseWriter, fileInfo *file.Info, dataKey []byte, i int, target *Scheme, chunk io.Reader) (int64, error) {
+	chunkSize := int64(target.Size)
+
+	// the chunk is compressed before it is encrypted, encrypted data does not compress
+	if fileInfo.Compression != "" {
+		spooled, compressedSize, err := compressChunk(fileInfo.Compression, chunk)
+		if err != nil {
+			log.Printf("Error compressing chunk: %v", err)
+			return 0, err
+		}
+		defer spooled.Close()
+		chunk, chunkSize = spooled, compressedSize
+	}
+
+	if dataKey != nil {
+		var err error
+		if chunk, err = crypt.NewEncrypter(dataKey, i, chunk); err != nil {
+			log.Printf("Error encrypting chunk: %v", err)
+			return 0, err
+		}
+		chunkSize = crypt.EncryptedSize(chunkSize)
+	}
 
-	if err = m.commitScheme(scheme); err != nil {
-		http.Error(w, "Error committing chunks", http.StatusInternalServerError)
+	storedHash, tmpFilePath, err := m.storeChunk(w, target, chunk, chunkSize)
+	if err != nil {
+		log.Printf("Error storing chunk: %v", err)
+		return 0, err
+	}
+
+	target.Tmpfile = tmpFilePath                      // temporary filename on the storage side
+	target.URL += "/" + storedMark + "/" + storedHash // url based on hash
+	return chunkSize, nil
+}
+
+// storeScheme stores the file whose content was uploaded to the segments of the scheme.
+// If the content with the hash of the file is stored already, the name is linked to it
+// and the segments are rolled back; otherwise they are committed and replicated. If the same
+// content was stored by a concurrent upload meanwhile, the name is linked to that one and the
+// committed segments and replicas are deleted.
+func (m *Manager) storeScheme(fileInfo *file.Info, opts database.StoreOptions, scheme []*Scheme) error {
+	if _, err := m.db.LoadMeta(fileInfo.Hash); err == nil {
+		log.Printf("File with hash '%s' already exist. STORE & ROLLBACK", fileInfo.Hash)
+		go m.rollbackScheme(scheme)
+
+		fileInfo.Size = 0
+		return m.Store(fileInfo, opts)
+	}
+
+	if err := m.commitScheme(scheme); err != nil {
 		log.Printf("Error committing chunks: %v", err)
-		rollback = true
-		return
+		go m.discardCopies(scheme)
+		return errors.New("Error committing chunks")
 	}
 
-	fileInfo := &file.Info{
-		Hash:     hash,
-		Name:     filename,
-		Size:     int64(size),
-		Metadata: metadata,
+	committed := make([]string, len(scheme))
+	for i, target := range scheme {
+		committed[i] = strings.Replace(target.URL, "upload", storedMark, 1)
+	}
+
+	copies, err := m.replicate(committed, fileInfo.StorageClass)
+	if err != nil {
+		log.Printf("Error replicating chunks: %v", err)
+		m.releaseSegments(scheme)
+		go m.reclaim([]*file.Meta{{Hash: fileInfo.Hash, Metadata: committed}})
+		return errors.New("Error replicating chunks")
+	}
+	replicas := make([]string, len(copies))
+	for i, replica := range copies {
+		replicas[i] = replica.URL
 	}
-	m.Store(hash, fileInfo)
+	fileInfo.Metadata = slices.Clone(committed)
+	fileInfo.Replicas = slices.Clone(replicas)
 
-	log.Printf("filename: %s size: %v sha256: %v uploaded successfully", filename, size, hash)
+	// the segments are in the metadata store from then on, unless they are reclaimed below
+	err = m.Store(fileInfo, opts)
+	m.releaseSegments(scheme)
+	m.releaseSegments(copies)
 
-	prettyJSON, _ := json.MarshalIndent(fileInfo, "", "    ")
-	log.Print(string(prettyJSON))
+	if err != nil {
+		// the segments are committed already, so they are deleted instead of rolled back
+		go m.reclaim([]*file.Meta{{Hash: fileInfo.Hash, Metadata: committed, Replicas: replicas}})
+		return err
+	}
+
+	if !slices.Equal(fileInfo.Metadata, committed) {
+		log.Printf("Content with hash '%s' was stored concurrently, deleting the committed chunks", fileInfo.Hash)
+		go m.reclaim([]*file.Meta{{Hash: fileInfo.Hash, Metadata: committed, Replicas: replicas}})
+	}
+	return nil
 }
 
 // downloadHandler handles the file download.
 func (m *Manager) downloadHandler(w http.ResponseWriter, r *http.Request) {
-	filename := filepath.Base(r.URL.Path)
+	filename := objectName(r)
+	if !m.authorize(w, r, file.ActionRead, filename) {
+		return
+	}
 
-	fileInfo, err := m.Load(filename)
+	fileInfo, err := m.loadRequested(r)
 	if err != nil {
 		http.NotFound(w, r)
 		log.Printf("File not found: %s", filename)
 		return
 	}
 
-	w.Header().Add("Content-Length", strconv.FormatInt(fileInfo.Size, 10))
-	w.Header().Add("X-Hash", fileInfo.Hash)
-	w.Header().Add("Server", "Distributed Storage System")
-	w.Header().Add("Content-Type", "application/octet-stream")
+	dataKey, err := m.dataKey(r, fileInfo)
+	if err != nil {
+		log.Printf("Data key of %s: %v", filename, err)
+		http.Error(w, keyError(err), keyStatus(err))
+		return
+	}
+	m.recordRead(fileInfo.Hash)
+
+	// a single byte range is served if the logical sizes of the segments are known
+	var (
+		sizes               = segmentSizes(fileInfo)
+		start, length, part = int64(0), fileInfo.Size, false
+	)
+	if header := r.Header.Get("Range"); header != "" && sizes != nil {
+		if start, length, part, err = byteRange(header, fileInfo.Size); err != nil {
+			w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(fileInfo.Size, 10))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
+	writeFileHeaders(w, fileInfo)
+	if part {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, fileInfo.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	var offset int64 // logical offset of the segment
+	for i, chunkURL := range fileInfo.Metadata {
+		// skip is the number of logical bytes of the segment before the range, n those in the range
+		skip, n := int64(0), int64(-1)
+		if part {
+			first := offset
+			offset += sizes[i]
+			if offset <= start || first >= start+length {
+				continue
+			}
+			skip = max(start-first, 0)
+			if n = min(offset, start+length) - first - skip; skip == 0 && n == sizes[i] {
+				n = -1
+			}
+		}
 
-	var resp *http.Response
-	for _, chunkURL := range fileInfo.Metadata {
-		chunkURL = strings.Replace(chunkURL, storedMark, "download", 1)
 		log.Printf("Retrieving chunk: %s", chunkURL)
 
-		resp, err = m.retrieveChunk(chunkURL)
-		if err != nil {
-			log.Printf("Error reading chunk %s: %v", chunkURL, err)
+		if !m.downloadSegment(w, fileInfo, dataKey, i, chunkURL, skip, n) {
 			return
 		}
+	}
+	log.Printf("filename: %s size: %v sha256: %v downloaded successfully", filename, fileInfo.Size, fileInfo.Hash)
+}
 
-		hasher := sha256.New()
-		multWriter := io.MultiWriter(w, hasher)
-		if _, err := io.Copy(multWriter, resp.Body); err != nil && err != io.EOF {
-			resp.Body.Close()
-			log.Printf("Error writing chunk %s: %v", chunkURL, err)
-			return
+// downloadSegment writes the segment i of the file from any of its copies, decrypted and decompressed,
+// skipping skip bytes and writing n of them, the rest if n is negative. It reports whether the segment
+// was written; otherwise the download is aborted.
+func (m *Manager) downloadSegment(w http.ResponseWriter, fileInfo *file.Info, dataKey []byte, i int, chunkURL string, skip, n int64) bool {
+	resp, chunkURL, err := m.retrieveSegment(file.Copies(chunkURL, fileInfo.Replicas))
+	if err != nil {
+		log.Printf("Error reading chunk %d of %s: %v", i, fileInfo.Name, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	// the segment hash is the hash of the stored, possibly compressed and encrypted, bytes
+	hasher := sha256.New()
+	stored := io.TeeReader(resp.Body, hasher)
+	chunk := stored
+	if dataKey != nil {
+		if chunk, err = crypt.NewDecrypter(dataKey, i, chunk); err != nil {
+			log.Printf("Error decrypting chunk %s: %v", chunkURL, err)
+			http.Error(w, "Error decrypting the file", http.StatusInternalServerError)
+			return false
 		}
+	}
+
+	if fileInfo.Compression != "" {
+		decompressed, err := compress.NewReader(fileInfo.Compression, chunk)
+		if err != nil {
+			log.Printf("Error decompressing chunk %s: %v", chunkURL, err)
+			return false
+		}
+		defer decompressed.Close()
+		chunk = decompressed
+	}
+
+	if skip > 0 {
+		_, err = io.CopyN(io.Discard, chunk, skip)
+	}
+	if err == nil && n < 0 {
+		_, err = io.Copy(w, chunk)
+	} else if err == nil {
+		_, err = io.CopyN(w, chunk, n)
+	}
+	if err != nil && err != io.EOF {
+		log.Printf("Error writing chunk %s: %v", chunkURL, err)
+		return false
+	}
+
+	// the hash is verified when the whole segment was read
+	if skip == 0 && n < 0 {
+		io.Copy(io.Discard, stored)
 
 		calculatedHash := hex.EncodeToString(hasher.Sum(nil))
 		expectedHash := filepath.Base(chunkURL)
 		if calculatedHash != expectedHash {
-			resp.Body.Close()
 			log.Printf("Hash mismatch for chunk %s", chunkURL)
-			return
+			return false
 		}
-		resp.Body.Close()
 	}
-	log.Printf("filename: %s size: %v sha256: %v downloaded successfully", filename, fileInfo.Size, fileInfo.Hash)
+	return true
 }
 
-// validateRequest checks if the file already exists in the database.
-func (m *Manager) validateRequest(filename string, hash string) error {
-	fileInfo, err := m.Load(filename, hash)
+// dataKey returns the data key of the encrypted file, nil if the file is not encrypted.
+// The key of a file encrypted with a customer key must be presented with the request.
+func (m *Manager) dataKey(r *http.Request, fileInfo *file.Info) ([]byte, error) {
+	if fileInfo.Encryption == nil {
+		return nil, nil
+	}
+
+	if fingerprint := fileInfo.Encryption.Fingerprint; fingerprint != "" {
+		key, err := customerKey(r)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, ErrKeyRequired
+		}
+		if !hmac.Equal([]byte(crypt.Fingerprint(key)), []byte(fingerprint)) {
+			return nil, ErrKeyMismatch
+		}
+		return crypt.UnwrapCustomer(key, fileInfo.Encryption)
+	}
+
+	if m.keyring == nil {
+		return nil, errors.New("the file is encrypted but no keyfile is configured")
+	}
+	return m.keyring.Unwrap(fileInfo.Encryption)
+}
+
+// mayRead reports whether the policy allows reading a name that references the content with the hash.
+// Without a policy the API is open.
+func (m *Manager) mayRead(policy *file.Policy, hash string) (bool, error) {
+	if policy == nil || policy.Admin {
+		return true, nil
+	}
+
+	names, err := m.db.Names(hash)
 	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(names, func(name string) bool {
+		return policy.Allows(file.ActionRead, name)
+	}), nil
+}
+
+// loadRequested loads the file addressed by the request, or its version given by the versionId parameter.
+// Expired files are not found.
+func (m *Manager) loadRequested(r *http.Request) (*file.Info, error) {
+	var (
+		filename = objectName(r)
+		fileInfo *file.Info
+		err      error
+	)
+
+	if version := r.URL.Query().Get("versionId"); version != "" {
+		fileInfo, err = m.db.LoadVersion(filename, version)
+	} else {
+		fileInfo, err = m.Load(filename)
+	}
+
+	if err == nil && fileInfo.Expired(time.Now()) {
+		err = database.ErrNotFound
+	}
+	return fileInfo, err
+}
+
+// validateRequest checks if the file can be stored under the name and, if the client
+// sent the hash of content that is already stored and may read it, stores the name without uploading.
+func (m *Manager) validateRequest(fileInfo *file.Info, opts database.StoreOptions, policy *file.Policy) error {
+	latest, err := m.Load(fileInfo.Name)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
 		return err
 	}
 
-	if fileInfo.Name != "" {
+	if err = opts.Check(latest); errors.Is(err, database.ErrExists) {
 		return ErrAlreadyExist
+
+	} else if err != nil {
+		return err
 	}
 
-	log.Printf("validateRequest: add file %s with hash %s", filename, hash)
-	m.Store(filename, fileInfo.Hash)
+	if fileInfo.Hash == "" {
+		return database.ErrNotFound
+	}
+
+	if _, err = m.db.LoadMeta(fileInfo.Hash); err != nil {
+		return err
+	}
+
+	// the hashes are listed, so knowing one does not prove having the content: a principal may only
+	// link content it can read under another name, otherwise it uploads the content
+	if readable, err := m.mayRead(policy, fileInfo.Hash); err != nil || !readable {
+		if err == nil {
+			err = database.ErrNotFound
+		}
+		return err
+	}
+
+	if err = m.Store(fileInfo, opts); err != nil {
+		return err
+	}
+
+	log.Printf("validateRequest: add file %s with hash %s", fileInfo.Name, fileInfo.Hash)
 	return nil
 }
+
+// listHandler lists the files whose names start with the request path and the prefix parameter,
+// optionally filtered by content type and user metadata.
+func (m *Manager) listHandler(w http.ResponseWriter, r *http.Request) {
+	opts := database.ListOptions{
+		Prefix:   strings.TrimPrefix(r.URL.Path, "/") + r.URL.Query().Get("prefix"),
+		Versions: r.URL.Query().Has("versions"),
+	}
+	listOptions(r, &opts)
+
+	policy, err := m.policy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list, err := m.db.List(opts)
+	if err != nil {
+		log.Printf("List %s: %v", opts.Prefix, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	files := make([]*file.Info, 0, len(list))
+	for _, fileInfo := range list {
+		// the names the principal may not list are left out
+		if !fileInfo.Expired(now) && (policy == nil || policy.Allows(file.ActionList, fileInfo.Name)) {
+			files = append(files, fileInfo)
+		}
+	}
+	writeJSON(w, files)
+}
+
+// deleteHandler deletes the version given by the versionId parameter, or all versions of the file.
+// The content no longer referenced by any name is removed from the storages in the background.
+func (m *Manager) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	filename := objectName(r)
+	version := r.URL.Query().Get("versionId")
+	if !m.authorize(w, r, file.ActionDelete, filename) {
+		return
+	}
+
+	released, err := m.db.Delete(filename, version)
+	if errors.Is(err, database.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+
+	} else if err != nil {
+		log.Printf("Delete %s (%s): %v", filename, version, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("filename: %s version: %s deleted successfully", filename, version)
+	go m.reclaim(released)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreHandler makes a copy of an old version the latest version of the file.
+func (m *Manager) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	filename := objectName(r)
+	version := r.URL.Query().Get("versionId")
+	if !m.authorize(w, r, file.ActionWrite, filename) {
+		return
+	}
+
+	opts, err := m.storeOptions(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !opts.Versioned {
+		http.Error(w, "Versioning is disabled", http.StatusBadRequest)
+		return
+	}
+
+	old, err := m.db.LoadVersion(filename, version)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	releaseQuota, err := m.reserveQuota(owner(r), filename, old.Size, opts)
+	if err != nil {
+		http.Error(w, err.Error(), storeStatus(err))
+		return
+	}
+	defer releaseQuota()
+
+	// the restored version keeps the attributes of the old one and belongs to the principal restoring it
+	fileInfo := old
+	fileInfo.Metadata = nil
+	fileInfo.Owner = owner(r)
+	if err = m.Store(fileInfo, opts); err != nil {
+		http.Error(w, err.Error(), storeStatus(err))
+		return
+	}
+
+	log.Printf("filename: %s version: %s restored as %s", filename, version, fileInfo.Version)
+	setVersionHeaders(w, fileInfo)
+}
+
+// storeOptions returns the store options of the namespace of the file.
+func (m *Manager) storeOptions(filename string) (database.StoreOptions, error) {
+	ns, err := m.db.Namespace(file.NamespaceOf(filename))
+	if err != nil {
+		return database.StoreOptions{}, err
+	}
+	return database.StoreOptions{Versioned: ns.Versioning}, nil
+}
+
+// objectName returns the file name addressed by the request path.
+func objectName(r *http.Request) string {
+	return cleanName(r.URL.Path)
+}
+
+// cleanName returns the file name without leading slash and relative elements.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// expiry returns the expiry time requested by the X-Expires header (RFC 3339 or HTTP date)
+// or the X-Expires-After header (duration such as 720h, or seconds), nil if there is none.
+func expiry(r *http.Request, now time.Time) (*time.Time, error) {
+	if value := r.Header.Get("X-Expires"); value != "" {
+		expires, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if expires, err = http.ParseTime(value); err != nil {
+				return nil, errors.New("invalid X-Expires header")
+			}
+		}
+		return &expires, nil
+	}
+
+	if value := r.Header.Get("X-Expires-After"); value != "" {
+		after, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, errors.New("invalid X-Expires-After header")
+			}
+			after = time.Duration(seconds) * time.Second
+		}
+
+		if after <= 0 {
+			return nil, errors.New("invalid X-Expires-After header")
+		}
+
+		expires := now.Add(after)
+		return &expires, nil
+	}
+	return nil, nil
+}
+
+// setVersionHeaders sets the version id and the entity tag, which is the SHA-256 of the content.
+func setVersionHeaders(w http.ResponseWriter, fileInfo *file.Info) {
+	w.Header().Set("X-Version-Id", fileInfo.Version)
+	w.Header().Set("ETag", `"`+fileInfo.Hash+`"`)
+}
+
+// etag returns the entity tag of a precondition header without quotes.
+func etag(value string) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimSpace(value), "W/"), `"`)
+}
+
+// storeStatus maps a metadata store error to the HTTP status code.
+func storeStatus(err error) int {
+	switch {
+	case err == ErrAlreadyExist:
+		return http.StatusForbidden
+
+	case errors.Is(err, database.ErrPrecondition):
+		return http.StatusPreconditionFailed
+
+	case errors.Is(err, database.ErrExists), errors.Is(err, database.ErrVersions):
+		return http.StatusConflict
+
+	case errors.Is(err, database.ErrNotFound):
+		return http.StatusNotFound
+
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// keyStatus returns the HTTP status of an error getting the data key of a file.
+func keyStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrKeyRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrKeyMismatch):
+		return http.StatusForbidden
+	case errors.Is(err, errCustomerKey):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// keyError returns the message of an error getting the data key of a file;
+// the errors of server-side keys are not shown to the client.
+func keyError(err error) string {
+	if keyStatus(err) == http.StatusInternalServerError {
+		return "Error decrypting file"
+	}
+	return err.Error()
+}
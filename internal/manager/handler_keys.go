@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"log"
+	"net/http"
+)
+
+// rotateHandler reloads the keyfile and wraps the data keys of all content again
+// with the current master key, so the older master keys can be removed.
+func (m *Manager) rotateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if m.keyring == nil {
+		http.Error(w, "Server-side encryption is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	if err := m.keyring.Reload(); err != nil {
+		log.Printf("Failed to reload the keyfile: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	current := m.keyring.Current()
+	rewrapped, failed := 0, 0
+
+	// the content is paged by hash, so the keys that fail do not hold up the ones after them
+	var after string
+	for {
+		list, err := m.db.StaleEncryption(current, after, lifecycleBatch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for _, metadata := range list {
+			enc, err := m.keyring.Rewrap(metadata.Encryption)
+			if err == nil {
+				err = m.db.SetEncryption(metadata.Hash, enc)
+			}
+			if err != nil {
+				log.Printf("Key rotation: content %s: %v", metadata.Hash, err)
+				failed++
+				continue
+			}
+			rewrapped++
+		}
+
+		if len(list) < lifecycleBatch {
+			break
+		}
+		after = list[len(list)-1].Hash
+	}
+
+	log.Printf("Key rotation to %s: %d data keys wrapped again, %d failed", current, rewrapped, failed)
+	writeJSON(w, map[string]any{
+		"current":   current,
+		"rewrapped": rewrapped,
+		"failed":    failed,
+	})
+}
@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+// lifecycleHandler lists the lifecycle rules, or updates and deletes one rule.
+func (m *Manager) lifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/lifecycle"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		rules, err := m.db.Rules()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if rules == nil {
+			rules = []*file.Rule{}
+		}
+		writeJSON(w, rules)
+
+	case r.Method == http.MethodPut && id != "":
+		rule := &file.Rule{}
+		if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+			http.Error(w, "Invalid lifecycle rule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule.ID = id
+
+		switch {
+		case rule.StorageClass == "" && rule.Days <= 0:
+			http.Error(w, "Invalid lifecycle rule: days must be positive", http.StatusBadRequest)
+			return
+
+		case rule.StorageClass != "" && !validClass(rule.StorageClass):
+			http.Error(w, "Invalid lifecycle rule: invalid storage class "+rule.StorageClass, http.StatusBadRequest)
+			return
+
+		case rule.Days < 0 || rule.IdleDays < 0 || rule.MinReads < 0:
+			http.Error(w, "Invalid lifecycle rule: negative days or reads", http.StatusBadRequest)
+			return
+		}
+
+		if err := m.db.SetRule(rule); err != nil {
+			log.Printf("Failed to update lifecycle rule %s: %v", id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Lifecycle rule %s updated: %+v", id, *rule)
+		writeJSON(w, rule)
+
+	case r.Method == http.MethodDelete && id != "":
+		err := m.db.DeleteRule(id)
+		if errors.Is(err, database.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Lifecycle rule %s deleted", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,90 @@
+package manager
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"dcloud/internal/compress"
+	"dcloud/internal/file"
+)
+
+// namespaceHandler lists the namespaces, or reads and updates the settings of one namespace.
+func (m *Manager) namespaceHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/namespaces"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		list, err := m.db.Namespaces()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if list == nil {
+			list = []*file.Namespace{}
+		}
+		writeJSON(w, list)
+
+	case r.Method == http.MethodGet:
+		ns, err := m.db.Namespace(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, ns)
+
+	case r.Method == http.MethodPut && name != "" && !strings.Contains(name, "/"):
+		ns := &file.Namespace{}
+		if err := json.NewDecoder(r.Body).Decode(ns); err != nil {
+			http.Error(w, "Invalid namespace settings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ns.Name = name
+
+		if !compress.Valid(ns.Compression) {
+			http.Error(w, "Invalid namespace settings: unsupported compression "+ns.Compression, http.StatusBadRequest)
+			return
+		}
+
+		if !ValidPlacement(ns.Placement) {
+			http.Error(w, "Invalid namespace settings: unknown placement "+ns.Placement, http.StatusBadRequest)
+			return
+		}
+
+		if !ValidDomain(ns.FailureDomain) {
+			http.Error(w, "Invalid namespace settings: unknown failure domain "+ns.FailureDomain, http.StatusBadRequest)
+			return
+		}
+
+		if ns.MinDomains < 0 {
+			http.Error(w, "Invalid namespace settings: negative minDomains", http.StatusBadRequest)
+			return
+		}
+
+		if ns.StorageClass != "" && !validClass(ns.StorageClass) {
+			http.Error(w, "Invalid namespace settings: invalid storage class "+ns.StorageClass, http.StatusBadRequest)
+			return
+		}
+
+		if err := m.db.SetNamespace(ns); err != nil {
+			log.Printf("Failed to update namespace %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Namespace %s updated: %+v", name, *ns)
+		writeJSON(w, ns)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeJSON writes the value as indented JSON.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(v)
+}
@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+// policyHandler lists the access policies, or reads, updates and deletes the policy of one principal.
+func (m *Manager) policyHandler(w http.ResponseWriter, r *http.Request) {
+	principal := strings.Trim(strings.TrimPrefix(r.URL.Path, "/policies"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && principal == "":
+		list, err := m.db.Policies()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if list == nil {
+			list = []*file.Policy{}
+		}
+		writeJSON(w, list)
+
+	case r.Method == http.MethodGet:
+		policy, err := m.db.Policy(principal)
+		if errors.Is(err, database.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, policy)
+
+	case r.Method == http.MethodPut && principal != "":
+		policy := &file.Policy{}
+		if err := json.NewDecoder(r.Body).Decode(policy); err != nil {
+			http.Error(w, "Invalid policy: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		policy.Principal = principal
+
+		if err := validatePolicy(policy); err != nil {
+			http.Error(w, "Invalid policy: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := m.db.SetPolicy(policy); err != nil {
+			log.Printf("Failed to update policy of %s: %v", principal, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Policy of %s updated: %+v", principal, *policy)
+		writeJSON(w, policy)
+
+	case r.Method == http.MethodDelete && principal != "":
+		err := m.db.DeletePolicy(principal)
+		if errors.Is(err, database.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Policy of %s deleted", principal)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validatePolicy checks the actions of the grants.
+func validatePolicy(policy *file.Policy) error {
+	for _, grant := range policy.Grants {
+		for _, action := range grant.Actions {
+			switch action {
+			case file.ActionRead, file.ActionWrite, file.ActionDelete, file.ActionList:
+			default:
+				return fmt.Errorf("unknown action '%s'", action)
+			}
+		}
+	}
+	return nil
+}
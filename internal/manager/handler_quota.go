@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+// QuotaStatus reports the quota of a principal or namespace with its usage
+// and the usage reserved by the writes in progress. Zero limits are unlimited.
+type QuotaStatus struct {
+	file.Quota
+	Usage    file.Usage  `json:"usage"`
+	Reserved *file.Usage `json:"reserved,omitempty"`
+}
+
+// quotaHandler lists the quotas and usage of the principals and namespaces,
+// or reads, updates and deletes the quota of one of them, addressed as /quotas/<scope>/<name>.
+func (m *Manager) quotaHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/quotas"), "/")
+	scope, name, _ := strings.Cut(path, "/")
+
+	if path != "" && (scope != file.ScopePrincipal && scope != file.ScopeNamespace || name == "") {
+		http.Error(w, "Quotas are addressed as /quotas/principal/<id> or /quotas/namespace/<name>", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		list, err := m.quotaStatuses()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, list)
+
+	case r.Method == http.MethodGet:
+		quota, err := m.db.Quota(scope, name)
+		if errors.Is(err, database.ErrNotFound) {
+			quota, err = &file.Quota{Scope: scope, Name: name}, nil
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		usage, err := m.db.Usage(scope, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, m.quotaStatus(quota, usage))
+
+	case r.Method == http.MethodPut:
+		quota := &file.Quota{}
+		if err := json.NewDecoder(r.Body).Decode(quota); err != nil {
+			http.Error(w, "Invalid quota: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		quota.Scope, quota.Name = scope, name
+
+		if quota.MaxObjects < 0 || quota.MaxBytes < 0 || quota.MaxStoredBytes < 0 {
+			http.Error(w, "Invalid quota: negative limit", http.StatusBadRequest)
+			return
+		}
+
+		if err := m.db.SetQuota(quota); err != nil {
+			log.Printf("Failed to update quota of %s %s: %v", scope, name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Quota of %s %s updated: %+v", scope, name, *quota)
+		writeJSON(w, quota)
+
+	case r.Method == http.MethodDelete:
+		err := m.db.DeleteQuota(scope, name)
+		if errors.Is(err, database.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Quota of %s %s deleted", scope, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// quotaStatuses lists the principals and namespaces that have a quota or a usage.
+func (m *Manager) quotaStatuses() ([]*QuotaStatus, error) {
+	quotas, err := m.db.Quotas()
+	if err != nil {
+		return nil, err
+	}
+
+	usages, err := m.db.Usages()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*QuotaStatus{}
+	byKey := make(map[string]*QuotaStatus)
+	for _, quota := range quotas {
+		status := &QuotaStatus{Quota: *quota, Usage: file.Usage{Scope: quota.Scope, Name: quota.Name}}
+		byKey[quota.Scope+"/"+quota.Name] = status
+		list = append(list, status)
+	}
+
+	for _, usage := range usages {
+		status, found := byKey[usage.Scope+"/"+usage.Name]
+		if !found {
+			status = &QuotaStatus{Quota: file.Quota{Scope: usage.Scope, Name: usage.Name}}
+			list = append(list, status)
+		}
+		status.Usage = *usage
+	}
+
+	for i, status := range list {
+		list[i] = m.quotaStatus(&status.Quota, &status.Usage)
+	}
+	return list, nil
+}
+
+// quotaStatus returns the status of the quota with the usage and the current reservations.
+func (m *Manager) quotaStatus(quota *file.Quota, usage *file.Usage) *QuotaStatus {
+	status := &QuotaStatus{Quota: *quota, Usage: *usage}
+
+	m.quotaLock.Lock()
+	if reserved, found := m.reserved[quota.Scope+"/"+quota.Name]; found {
+		copied := *reserved
+		status.Reserved = &copied
+	}
+	m.quotaLock.Unlock()
+	return status
+}
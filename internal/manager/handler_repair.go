@@ -0,0 +1,39 @@
+package manager
+
+import (
+	"log"
+	"net/http"
+)
+
+// repairHandler returns the progress of the repair with its queue.
+func (m *Manager) repairHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, m.repairStatus(repairListed))
+}
+
+// drainHandler starts draining the storage with the URL of the storage parameter with PUT,
+// and stops it with DELETE.
+func (m *Manager) drainHandler(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("storage")
+
+	var draining bool
+	switch {
+	case r.Method == http.MethodPut && url != "":
+		draining = true
+	case r.Method == http.MethodDelete && url != "":
+		draining = false
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !m.drainStorage(url, draining) {
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("Storage %s draining: %v", url, draining)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package manager
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"dcloud/internal/file"
+)
+
+// headHandler returns the headers of the file without contacting the storages.
+func (m *Manager) headHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.authorize(w, r, file.ActionRead, objectName(r)) {
+		return
+	}
+
+	fileInfo, err := m.loadRequested(r)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// the headers of a file encrypted with a customer key are shown only with the key
+	if fileInfo.Encryption != nil && fileInfo.Encryption.Fingerprint != "" {
+		if _, err = m.dataKey(r, fileInfo); err != nil {
+			w.WriteHeader(keyStatus(err))
+			return
+		}
+	}
+	writeFileHeaders(w, fileInfo)
+}
+
+// statHandler returns the file information with the placement of its segments
+// and the state of the storages that hold them.
+func (m *Manager) statHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.authorize(w, r, file.ActionRead, objectName(r)) {
+		return
+	}
+
+	fileInfo, err := m.loadRequested(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	stat := &Stat{
+		Info:     *fileInfo,
+		Segments: make([]*SegmentStat, len(fileInfo.Metadata)),
+	}
+	stat.Info.Metadata = nil
+
+	sizes := segmentSizes(fileInfo)
+
+	var wg sync.WaitGroup
+	probe := func(index int, segmentURL string) *SegmentStat {
+		segment := &SegmentStat{
+			Index:   index,
+			URL:     segmentURL,
+			Storage: storageURL(segmentURL),
+			Hash:    filepath.Base(segmentURL),
+		}
+		if sizes != nil {
+			segment.LogicalSize = sizes[index]
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.probeSegment(segment)
+		}()
+		return segment
+	}
+
+	// the replicas are listed with the index of the segment they copy
+	for i, segmentURL := range fileInfo.Metadata {
+		stat.Segments[i] = probe(i, segmentURL)
+		for _, replicaURL := range file.Copies(segmentURL, fileInfo.Replicas)[1:] {
+			stat.Replicas = append(stat.Replicas, probe(i, replicaURL))
+		}
+	}
+	wg.Wait()
+
+	writeJSON(w, stat)
+}
+
+// probeSegment checks the segment on its storage.
+func (m *Manager) probeSegment(segment *SegmentStat) {
+	m.RLock()
+	_, segment.Registered = m.storages[segment.Storage]
+	m.RUnlock()
+
+	url := strings.Replace(segment.URL, storedMark, "download", 1)
+	resp, err := m.storageRequest(http.MethodHead, url, nil)
+	if err != nil {
+		log.Printf("probeSegment %s: %v", url, err)
+		segment.Status = SegmentUnreachable
+		return
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		segment.Status = SegmentOK
+		segment.Size = resp.ContentLength
+
+	case http.StatusNotFound:
+		segment.Status = SegmentMissing
+
+	default:
+		segment.Status = SegmentError
+	}
+}
+
+// storageURL returns the base URL of the storage holding the segment.
+func storageURL(segmentURL string) string {
+	u, err := url.Parse(segmentURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -2,10 +2,13 @@ package manager
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // registerHandler registers a new storage.
@@ -42,15 +45,70 @@ func (m *Manager) storageRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// storages before heartbeats do not report their free space, nor their segments before reconciliation
+	free, _ := strconv.Atoi(r.Header.Get("X-Free"))
+	segments, _ := strconv.Atoi(r.Header.Get("X-Segments"))
+	temporary, _ := strconv.Atoi(r.Header.Get("X-Temporary"))
+	reconcile := r.Header.Get("X-Segments") != ""
+
 	if net.ParseIP(ip).To4() == nil {
 		ip = "[" + ip + "]"
 	}
 
-	url := "http://" + ip + addr
+	// storages serving TLS report it, so their segment URLs use HTTPS
+	scheme := "http"
+	if r.Header.Get("X-Scheme") == "https" {
+		scheme = "https"
+	}
+
+	host := ip
+	if name := r.Header.Get("X-Host"); name != "" {
+		if strings.ContainsAny(name, "/?#@:[] ") {
+			log.Printf("Invalid Host header: %v", name)
+			http.Error(w, "Invalid Host header", http.StatusBadRequest)
+			return
+		}
+		host = name
+	}
+
+	url := scheme + "://" + host + addr
 	storage := &Storage{
-		URL:   url,
-		Limit: limit,
-		Used:  used,
+		URL:       url,
+		Limit:     limit,
+		Used:      used,
+		Free:      free,
+		Segments:  segments,
+		Temporary: temporary,
+		Host:      host,
+		Class:     r.Header.Get("X-Class"),
+	}
+
+	if storage.Class != "" && !validClass(storage.Class) {
+		log.Printf("Invalid Class header: %v", storage.Class)
+		http.Error(w, "Invalid Class header", http.StatusBadRequest)
+		return
+	}
+
+	if err = parseTopology(r.Header.Get("X-Topology"), storage); err != nil {
+		log.Printf("Invalid Topology header: %v", err)
+		http.Error(w, "Invalid Topology header", http.StatusBadRequest)
+		return
+	}
+
+	// a storage must report more often than the manager fails storages that missed their heartbeats;
+	// storages before heartbeats do not report their interval
+	if value := r.Header.Get("X-Interval"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 || interval >= m.storageTimeout {
+			log.Printf("Invalid Interval header: %v", value)
+			http.Error(w, fmt.Sprintf("Heartbeat interval %s must be shorter than the storage timeout %s", value, m.storageTimeout), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// a heartbeat of a storage the manager does not know, as after a restart, registers it
+	if r.Header.Get("X-Heartbeat") == "true" && m.heartbeat(storage, reconcile) {
+		return
 	}
 
 	if err = m.addStorage(url, storage); err != nil {
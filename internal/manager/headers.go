@@ -0,0 +1,217 @@
+package manager
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dcloud/internal/crypt"
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+var (
+	errCustomerKey = errors.New("invalid customer key")
+	errRange       = errors.New("range not satisfiable")
+)
+
+const (
+	metaPrefix  = "X-Meta-"
+	maxMetaSize = 2048
+)
+
+// conditions copies the overwrite flag and the preconditions of the request to the store options.
+func conditions(r *http.Request, opts *database.StoreOptions) {
+	opts.Overwrite = r.Header.Get("X-Overwrite") == "true"
+	opts.IfMatch = etag(r.Header.Get("If-Match"))
+	opts.IfNoneMatch = etag(r.Header.Get("If-None-Match"))
+}
+
+// readAttributes copies the content headers and the X-Meta-* user metadata of the upload to the file info.
+func readAttributes(r *http.Request, fileInfo *file.Info) error {
+	fileInfo.ContentType = r.Header.Get("Content-Type")
+	fileInfo.ContentDisposition = r.Header.Get("Content-Disposition")
+	fileInfo.ContentEncoding = r.Header.Get("Content-Encoding")
+	fileInfo.CacheControl = r.Header.Get("Cache-Control")
+
+	size := 0
+	for key, values := range r.Header {
+		if !strings.HasPrefix(key, metaPrefix) || len(key) == len(metaPrefix) {
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimPrefix(key, metaPrefix))
+		if strings.ContainsAny(key, ".$") {
+			return errors.New("invalid metadata key " + key)
+		}
+
+		value := strings.Join(values, ",")
+		if size += len(key) + len(value); size > maxMetaSize {
+			return errors.New("user metadata is too large")
+		}
+
+		if fileInfo.UserMeta == nil {
+			fileInfo.UserMeta = make(map[string]string)
+		}
+		fileInfo.UserMeta[key] = value
+	}
+	return nil
+}
+
+// customerKey returns the key the client provided in X-Encryption-Key, nil if there is none.
+// X-Encryption-Key-SHA256, if sent, must be the base64 SHA-256 of the key.
+func customerKey(r *http.Request) ([]byte, error) {
+	encoded := r.Header.Get("X-Encryption-Key")
+	if encoded == "" {
+		return nil, nil
+	}
+
+	if r.TLS == nil {
+		return nil, fmt.Errorf("%w: customer keys are only accepted over TLS", errCustomerKey)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%w: X-Encryption-Key must be 32 bytes in base64", errCustomerKey)
+	}
+
+	if sum := r.Header.Get("X-Encryption-Key-SHA256"); sum != "" && sum != crypt.Fingerprint(key) {
+		return nil, fmt.Errorf("%w: X-Encryption-Key-SHA256 does not match the key", errCustomerKey)
+	}
+	return key, nil
+}
+
+// byteRange returns the first byte and the length of the range requested by the Range header.
+// ok is false if the header does not ask for a single byte range, so the whole file is served.
+func byteRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	// bytes=-n is the last n bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 {
+			return 0, 0, false, errRange
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		end = min(end, size-1)
+	}
+
+	if start >= size {
+		return 0, 0, false, errRange
+	}
+	return start, end - start + 1, true, nil
+}
+
+// segmentSizes returns the logical sizes of the segments of the file,
+// nil if they are not known, as for the multi-segment files stored before they were kept.
+func segmentSizes(fileInfo *file.Info) []int64 {
+	switch {
+	case len(fileInfo.SegmentSizes) == len(fileInfo.Metadata):
+		return fileInfo.SegmentSizes
+	case len(fileInfo.Metadata) == 1:
+		return []int64{fileInfo.Size}
+	}
+	return nil
+}
+
+// writeFileHeaders sets the headers describing the file: size, hash, version, upload time and attributes.
+func writeFileHeaders(w http.ResponseWriter, fileInfo *file.Info) {
+	w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size, 10))
+	w.Header().Set("X-Hash", fileInfo.Hash)
+	setVersionHeaders(w, fileInfo)
+	w.Header().Set("Server", "Distributed Storage System")
+
+	if !fileInfo.Created.IsZero() {
+		w.Header().Set("Last-Modified", fileInfo.Created.UTC().Format(http.TimeFormat))
+	}
+
+	if fileInfo.Expires != nil {
+		w.Header().Set("X-Expires", fileInfo.Expires.UTC().Format(time.RFC3339))
+	}
+
+	if segmentSizes(fileInfo) != nil {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	if fileInfo.Compression != "" {
+		w.Header().Set("X-Compression", fileInfo.Compression)
+	}
+
+	if fileInfo.Encryption != nil {
+		w.Header().Set("X-Server-Side-Encryption", fileInfo.Encryption.Algorithm)
+		if fileInfo.Encryption.Fingerprint != "" {
+			w.Header().Set("X-Encryption-Key-SHA256", fileInfo.Encryption.Fingerprint)
+		}
+	}
+	writeAttributes(w, fileInfo)
+}
+
+// writeAttributes sets the content headers and the user metadata of the file.
+func writeAttributes(w http.ResponseWriter, fileInfo *file.Info) {
+	contentType := fileInfo.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	headers := map[string]string{
+		"Content-Disposition": fileInfo.ContentDisposition,
+		"Content-Encoding":    fileInfo.ContentEncoding,
+		"Cache-Control":       fileInfo.CacheControl,
+	}
+
+	for key, value := range headers {
+		if value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+
+	for key, value := range fileInfo.UserMeta {
+		w.Header().Set(metaPrefix+key, value)
+	}
+}
+
+// listOptions returns the metadata filters of the listing request:
+// contentType=<type> and meta.<key>=<value>.
+func listOptions(r *http.Request, opts *database.ListOptions) {
+	query := r.URL.Query()
+	opts.ContentType = query.Get("contentType")
+
+	for key := range query {
+		if !strings.HasPrefix(key, "meta.") || len(key) == len("meta.") {
+			continue
+		}
+
+		if opts.Meta == nil {
+			opts.Meta = make(map[string]string)
+		}
+		opts.Meta[strings.ToLower(strings.TrimPrefix(key, "meta."))] = query.Get(key)
+	}
+}
@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+// lifecycle deletes the expired files and drops the expired direct uploads in the background.
+func (m *Manager) lifecycle() {
+	ticker := time.NewTicker(lifecycleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		m.expirePlans(now)
+		m.expire(now)
+	}
+}
+
+// expire deletes the versions whose expiry time has passed and the versions matched by the lifecycle rules.
+func (m *Manager) expire(now time.Time) {
+	m.expireBatches("expiry", func() ([]*file.Info, error) {
+		return m.db.Expiring(now, lifecycleBatch)
+	})
+
+	rules, err := m.db.Rules()
+	if err != nil {
+		log.Printf("Lifecycle: failed to load rules: %v", err)
+		return
+	}
+
+	for _, rule := range rules {
+		// transition rules move the content instead, see tiering
+		if rule.Days <= 0 || rule.StorageClass != "" {
+			continue
+		}
+
+		before := now.AddDate(0, 0, -rule.Days)
+		m.expireBatches("rule "+rule.ID, func() ([]*file.Info, error) {
+			return m.db.Older(rule.Prefix, before, lifecycleBatch)
+		})
+	}
+}
+
+// expireBatches deletes the versions returned by next until there are no more.
+// A version replaced after it was listed is kept.
+func (m *Manager) expireBatches(reason string, next func() ([]*file.Info, error)) {
+	for {
+		list, err := next()
+		if err != nil {
+			log.Printf("Lifecycle %s: %v", reason, err)
+			return
+		}
+
+		deleted := 0
+		for _, fileInfo := range list {
+			released, err := m.db.Delete(fileInfo.Name, fileInfo.Version, fileInfo.Created)
+			if errors.Is(err, database.ErrNotFound) {
+				continue
+
+			} else if err != nil {
+				log.Printf("Lifecycle %s: failed to delete %s (%s): %v", reason, fileInfo.Name, fileInfo.Version, err)
+				return
+			}
+
+			log.Printf("Lifecycle %s: %s (%s) deleted", reason, fileInfo.Name, fileInfo.Version)
+			m.reclaim(released)
+			deleted++
+		}
+
+		if len(list) < lifecycleBatch || deleted == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package manager
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"dcloud/internal/file"
+)
+
+// Placement policies, selected for the cluster by Config.Placement and for a namespace by its settings.
+const (
+	PlacementSpread         = "spread"
+	PlacementWeightedRandom = "weighted-random"
+	PlacementLeastUsed      = "least-used"
+	PlacementRoundRobin     = "round-robin"
+	PlacementConsistentHash = "consistent-hash"
+	PlacementFillOne        = "fill-one"
+)
+
+// Placement distributes the content of a file over the storages.
+type Placement interface {
+	// Place returns the segments of the file of size bytes with the given name on the storages,
+	// which all have space left and at least size bytes of it together. The manager lock is held.
+	Place(name string, size int, storages []*Storage) []*Scheme
+}
+
+// newPlacements returns an instance of every placement policy by its name.
+func newPlacements() map[string]Placement {
+	return map[string]Placement{
+		PlacementSpread:         spread{},
+		PlacementWeightedRandom: ordered(weightedRandom),
+		PlacementLeastUsed:      ordered(leastUsed),
+		PlacementRoundRobin:     &roundRobin{},
+		PlacementConsistentHash: ordered(consistentHash),
+		PlacementFillOne:        ordered(fillOne),
+	}
+}
+
+// ValidPlacement reports whether the placement policy is known; empty selects the default.
+func ValidPlacement(policy string) bool {
+	_, found := newPlacements()[policy]
+	return policy == "" || found
+}
+
+// placementPolicy returns the placement policy of the file and its failure domain constraint:
+// the ones of its namespace or else the ones of the cluster.
+func (m *Manager) placementPolicy(filename string) (Placement, spreading, error) {
+	name, constraint := m.placement, m.spreading
+
+	ns, err := m.db.Namespace(file.NamespaceOf(filename))
+	if err != nil {
+		return nil, constraint, err
+	}
+	if ns.Placement != "" {
+		name = ns.Placement
+	}
+	if ns.FailureDomain != "" {
+		constraint = spreading{level: ns.FailureDomain, min: ns.MinDomains}
+	}
+
+	policy, found := m.placements[name]
+	if !found {
+		return nil, constraint, fmt.Errorf("unknown placement policy %q", name)
+	}
+	return policy, constraint, nil
+}
+
+// spread stripes every file over all the storages, in proportion to the percentage of their limit that is free.
+type spread struct{}
+
+func (spread) Place(_ string, size int, storages []*Storage) []*Scheme {
+	var totalPercent float64
+	for _, storage := range storages {
+		storage.availablePercent = 100 - (float64(storage.Used) / float64(storage.Limit) * 100)
+		totalPercent += storage.availablePercent
+	}
+
+	// Calculate proportions for each storage
+	assigned := 0
+	for _, storage := range storages {
+		storage.proportion = int(float64(size) * (storage.availablePercent / totalPercent))
+		storage.fractional = float64(size)*(storage.availablePercent/totalPercent) - float64(storage.proportion)
+		assigned += storage.proportion
+	}
+
+	// Distribute the remaining bytes to the storages
+	remainder := size - assigned
+	for i := 0; i < remainder; i++ {
+		storages[i%len(storages)].proportion++
+	}
+
+	var scheme []*Scheme
+	for _, storage := range storages {
+		if storage.proportion > 0 {
+			scheme = append(scheme, &Scheme{URL: storage.URL, Size: storage.proportion})
+		}
+	}
+	return scheme
+}
+
+// ordered is a policy that fills the storages in the order it sorts them: the whole file goes to the first
+// one, and only what does not fit there goes on to the next ones.
+type ordered func(name string, storages []*Storage)
+
+func (order ordered) Place(name string, size int, storages []*Storage) []*Scheme {
+	storages = slices.Clone(storages)
+	order(name, storages)
+	return fill(size, storages)
+}
+
+// fill places size bytes on the storages in turn, as many as each has space for.
+func fill(size int, storages []*Storage) []*Scheme {
+	var scheme []*Scheme
+	for _, storage := range storages {
+		if size == 0 {
+			break
+		}
+
+		n := min(size, storage.Limit-storage.Used)
+		scheme = append(scheme, &Scheme{URL: storage.URL, Size: n})
+		size -= n
+	}
+	return scheme
+}
+
+// weightedRandom picks the storages at random, the ones with more space left more often.
+func weightedRandom(_ string, storages []*Storage) {
+	// a weighted sample without replacement: the storages sorted by u^(1/weight) for u uniform in (0,1],
+	// compared by its logarithm to keep the precision with weights in bytes
+	keys := make(map[*Storage]float64, len(storages))
+	for _, storage := range storages {
+		keys[storage] = math.Log(1-rand.Float64()) / float64(storage.Limit-storage.Used)
+	}
+	slices.SortFunc(storages, func(a, b *Storage) int {
+		return cmp.Compare(keys[b], keys[a])
+	})
+}
+
+// leastUsed picks the storages with the lowest part of their limit used first.
+func leastUsed(_ string, storages []*Storage) {
+	slices.SortFunc(storages, func(a, b *Storage) int {
+		if c := cmp.Compare(usedRatio(a), usedRatio(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.URL, b.URL)
+	})
+}
+
+// consistentHash picks the storages by rendezvous hashing of the file name, so a name keeps its storages
+// and only the names of a storage that joins or leaves move.
+func consistentHash(name string, storages []*Storage) {
+	weights := make(map[*Storage]uint64, len(storages))
+	for _, storage := range storages {
+		sum := sha256.Sum256([]byte(storage.URL + "\x00" + name))
+		weights[storage] = binary.BigEndian.Uint64(sum[:8])
+	}
+	slices.SortFunc(storages, func(a, b *Storage) int {
+		if c := cmp.Compare(weights[b], weights[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a.URL, b.URL)
+	})
+}
+
+// fillOne fills the storage registered first until it is full, then the next one.
+func fillOne(_ string, storages []*Storage) {
+	slices.SortFunc(storages, func(a, b *Storage) int {
+		if c := a.Registered.Compare(b.Registered); c != 0 {
+			return c
+		}
+		return strings.Compare(a.URL, b.URL)
+	})
+}
+
+// roundRobin starts every file on the storage after the one the previous file started on.
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (r *roundRobin) Place(_ string, size int, storages []*Storage) []*Scheme {
+	storages = slices.Clone(storages)
+	slices.SortFunc(storages, func(a, b *Storage) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+
+	start := int((r.next.Add(1) - 1) % uint64(len(storages)))
+	return fill(size, slices.Concat(storages[start:], storages[:start]))
+}
+
+func usedRatio(storage *Storage) float64 {
+	return float64(storage.Used) / float64(storage.Limit)
+}
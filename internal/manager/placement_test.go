@@ -0,0 +1,254 @@
+package manager
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newStorages returns storages with the given limits and used bytes, named s0, s1, ...
+func newStorages(usage ...[2]int) []*Storage {
+	storages := make([]*Storage, len(usage))
+	for i, u := range usage {
+		storages[i] = &Storage{
+			URL:        fmt.Sprintf("http://s%d:19000", i),
+			Limit:      u[0],
+			Used:       u[1],
+			Registered: time.Unix(int64(len(usage)-i), 0),
+		}
+	}
+	return storages
+}
+
+// placed returns the bytes of the scheme by storage URL.
+func placed(scheme []*Scheme) map[string]int {
+	sizes := make(map[string]int)
+	for _, target := range scheme {
+		sizes[target.URL] += target.Size
+	}
+	return sizes
+}
+
+func urls(storages []*Storage) []string {
+	list := make([]string, len(storages))
+	for i, storage := range storages {
+		list[i] = storage.URL
+	}
+	return list
+}
+
+func TestSpreadProportional(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage [][2]int
+		size  int
+		want  []int
+	}{
+		{"empty", [][2]int{{1000, 0}, {1000, 0}}, 600, []int{300, 300}},
+		{"free share", [][2]int{{1000, 0}, {1000, 500}, {1000, 750}}, 700, []int{400, 200, 100}},
+		{"remainder", [][2]int{{1000, 0}, {1000, 0}, {1000, 0}}, 100, []int{34, 33, 33}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storages := newStorages(tt.usage...)
+			sizes := placed(spread{}.Place("f", tt.size, storages))
+
+			total := 0
+			for i, storage := range storages {
+				if sizes[storage.URL] != tt.want[i] {
+					t.Errorf("%s got %d bytes, want %d", storage.URL, sizes[storage.URL], tt.want[i])
+				}
+				total += sizes[storage.URL]
+			}
+			if total != tt.size {
+				t.Errorf("placed %d bytes, want %d", total, tt.size)
+			}
+		})
+	}
+}
+
+func TestWeightedRandomProportional(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage [][2]int
+	}{
+		{"even", [][2]int{{1000, 0}, {1000, 0}}},
+		{"one to three", [][2]int{{1000, 900}, {1000, 700}}},
+		{"one to two to five", [][2]int{{1000, 900}, {1000, 800}, {1000, 500}}},
+	}
+
+	const trials = 20000
+	policy := ordered(weightedRandom)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storages := newStorages(tt.usage...)
+
+			free := 0
+			for _, storage := range storages {
+				free += storage.Limit - storage.Used
+			}
+
+			first := make(map[string]int)
+			for range trials {
+				scheme := policy.Place("f", 1, storages)
+				first[scheme[0].URL]++
+			}
+
+			for _, storage := range storages {
+				want := float64(storage.Limit-storage.Used) / float64(free)
+				got := float64(first[storage.URL]) / trials
+				if math.Abs(got-want) > 0.02 {
+					t.Errorf("%s picked %.3f of the time, want %.3f", storage.URL, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderedPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		order  ordered
+		usage  [][2]int
+		wanted []int
+	}{
+		{"least-used by ratio", leastUsed, [][2]int{{1000, 500}, {100, 10}, {2000, 1500}}, []int{1, 0, 2}},
+		{"least-used ties by URL", leastUsed, [][2]int{{1000, 0}, {1000, 0}}, []int{0, 1}},
+		{"fill-one by registration", fillOne, [][2]int{{1000, 0}, {1000, 0}, {1000, 0}}, []int{2, 1, 0}},
+		{"fill-one ignores usage", fillOne, [][2]int{{1000, 999}, {1000, 0}}, []int{1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storages := newStorages(tt.usage...)
+
+			got := slices.Clone(storages)
+			tt.order("f", got)
+
+			want := make([]*Storage, len(tt.wanted))
+			for i, index := range tt.wanted {
+				want[i] = storages[index]
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("order %v, want %v", urls(got), urls(want))
+			}
+
+			// the whole file goes to the first storage of the order
+			if scheme := tt.order.Place("f", 1, storages); len(scheme) != 1 || scheme[0].URL != want[0].URL {
+				t.Errorf("placed on %v, want %s", placed(scheme), want[0].URL)
+			}
+		})
+	}
+}
+
+func TestRoundRobinRotates(t *testing.T) {
+	storages := newStorages([2]int{1000, 0}, [2]int{1000, 0}, [2]int{1000, 0})
+	policy := &roundRobin{}
+
+	// the storages are taken in the order of their URLs, whatever order they are given in
+	slices.Reverse(storages)
+	var got []string
+	for range 6 {
+		scheme := policy.Place("f", 10, storages)
+		if len(scheme) != 1 {
+			t.Fatalf("placed on %d storages, want 1", len(scheme))
+		}
+		got = append(got, scheme[0].URL)
+	}
+
+	want := []string{"http://s0:19000", "http://s1:19000", "http://s2:19000", "http://s0:19000", "http://s1:19000", "http://s2:19000"}
+	if !slices.Equal(got, want) {
+		t.Errorf("started on %v, want %v", got, want)
+	}
+}
+
+func TestConsistentHashMinimalMovement(t *testing.T) {
+	const names = 2000
+	policy := ordered(consistentHash)
+
+	place := func(storages []*Storage) map[string]string {
+		placement := make(map[string]string, names)
+		for i := range names {
+			name := fmt.Sprintf("file-%d", i)
+			placement[name] = policy.Place(name, 1, storages)[0].URL
+		}
+		return placement
+	}
+
+	usage := slices.Repeat([][2]int{{1000, 0}}, 6)
+	all := newStorages(usage...)
+	before := place(all[:5])
+
+	t.Run("stable", func(t *testing.T) {
+		again := place(all[:5])
+		for name, url := range before {
+			if again[name] != url {
+				t.Errorf("%s moved from %s to %s", name, url, again[name])
+			}
+		}
+	})
+
+	t.Run("join", func(t *testing.T) {
+		after := place(all)
+		moved := 0
+		for name, url := range before {
+			if after[name] == url {
+				continue
+			}
+			moved++
+			if after[name] != all[5].URL {
+				t.Errorf("%s moved from %s to %s, not to the new storage", name, url, after[name])
+			}
+		}
+		// about a sixth of the names move to the new storage
+		if moved < names/12 || moved > names/4 {
+			t.Errorf("%d of %d names moved", moved, names)
+		}
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		left := all[2]
+		after := place(slices.Delete(slices.Clone(all[:5]), 2, 3))
+		for name, url := range before {
+			if url != left.URL && after[name] != url {
+				t.Errorf("%s moved from %s to %s, though its storage stayed", name, url, after[name])
+			}
+		}
+	})
+}
+
+func TestFillOverflows(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage [][2]int
+		size  int
+		want  []int
+	}{
+		{"fits the first", [][2]int{{100, 0}, {100, 0}}, 80, []int{80}},
+		{"overflows to the next", [][2]int{{100, 0}, {100, 50}}, 120, []int{100, 20}},
+		{"over three", [][2]int{{100, 60}, {100, 70}, {100, 0}}, 100, []int{40, 30, 30}},
+		{"exactly full", [][2]int{{100, 0}, {100, 0}}, 100, []int{100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storages := newStorages(tt.usage...)
+			scheme := fill(tt.size, storages)
+
+			got := make([]int, len(scheme))
+			for i, target := range scheme {
+				if target.URL != storages[i].URL {
+					t.Errorf("segment %d on %s, want %s", i, target.URL, storages[i].URL)
+				}
+				got[i] = target.Size
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("segments %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
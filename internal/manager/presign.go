@@ -0,0 +1,191 @@
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"dcloud/internal/auth"
+	"dcloud/internal/file"
+)
+
+// Query parameters of presigned URLs. All parameters of the URL are covered by the signature.
+const (
+	presignMethod      = "X-Dcloud-Method"
+	presignExpires     = "X-Dcloud-Expires"
+	presignPrincipal   = "X-Dcloud-Principal"
+	presignMaxSize     = "X-Dcloud-Max-Size"
+	presignContentType = "X-Dcloud-Content-Type"
+	presignSignature   = "X-Dcloud-Signature"
+
+	presignDefaultExpiry = time.Hour
+	presignMaxExpiry     = 7 * 24 * time.Hour
+)
+
+// PresignRequest describes the URL to mint.
+type PresignRequest struct {
+	Method      string `json:"method"`
+	Name        string `json:"name"`
+	VersionID   string `json:"versionId,omitempty"`
+	Expires     int    `json:"expires,omitempty"` // seconds, default 1 hour
+	MaxSize     int64  `json:"maxSize,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// Presigned is a minted URL.
+type Presigned struct {
+	URL     string    `json:"url"`
+	Method  string    `json:"method"`
+	Expires time.Time `json:"expires"`
+}
+
+// presignHandler mints a URL that allows a GET or PUT of the name until it expires, without credentials.
+// The caller must be allowed to read or write the name; the URL is used with the caller's rights.
+func (m *Manager) presignHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &PresignRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "Invalid presign request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := cleanName(req.Name)
+	expiry := time.Duration(req.Expires) * time.Second
+	if req.Expires == 0 {
+		expiry = presignDefaultExpiry
+	}
+
+	switch {
+	case name == "" || strings.HasSuffix(req.Name, "/"):
+		http.Error(w, "Invalid presign request: name is required", http.StatusBadRequest)
+		return
+
+	case req.Method != http.MethodGet && req.Method != http.MethodPut:
+		http.Error(w, "Invalid presign request: method must be GET or PUT", http.StatusBadRequest)
+		return
+
+	case expiry <= 0 || expiry > presignMaxExpiry:
+		http.Error(w, fmt.Sprintf("Invalid presign request: expiry must be positive and at most %v", presignMaxExpiry), http.StatusBadRequest)
+		return
+
+	case req.Method == http.MethodGet && (req.MaxSize != 0 || req.ContentType != ""):
+		http.Error(w, "Invalid presign request: size and content type constraints are for PUT", http.StatusBadRequest)
+		return
+	}
+
+	action := file.ActionRead
+	if req.Method == http.MethodPut {
+		action = file.ActionWrite
+	}
+	if !m.authorize(w, r, action, name) {
+		return
+	}
+
+	expires := time.Now().Add(expiry).Truncate(time.Second)
+	query := url.Values{}
+	query.Set(presignMethod, req.Method)
+	query.Set(presignExpires, strconv.FormatInt(expires.Unix(), 10))
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		query.Set(presignPrincipal, principal.ID)
+	}
+	if req.VersionID != "" {
+		query.Set("versionId", req.VersionID)
+	}
+	if req.MaxSize > 0 {
+		query.Set(presignMaxSize, strconv.FormatInt(req.MaxSize, 10))
+	}
+	if req.ContentType != "" {
+		query.Set(presignContentType, req.ContentType)
+	}
+
+	u := &url.URL{Path: "/" + name}
+	query.Set(presignSignature, m.presignSignature(u.EscapedPath(), query))
+	u.RawQuery = query.Encode()
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	log.Printf("Presigned %s %s until %v", req.Method, name, expires)
+	writeJSON(w, &Presigned{
+		URL:     scheme + "://" + r.Host + u.String(),
+		Method:  req.Method,
+		Expires: expires,
+	})
+}
+
+// presignSignature returns the hex HMAC-SHA256 of the path and the query without the signature.
+func (m *Manager) presignSignature(path string, query url.Values) string {
+	unsigned := url.Values{}
+	for key, values := range query {
+		if key != presignSignature {
+			unsigned[key] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, m.presignSecret)
+	fmt.Fprintf(mac, "%s\n%s", path, unsigned.Encode())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isPresigned reports whether the request carries a presigned URL.
+func isPresigned(r *http.Request) bool {
+	return r.URL.Query().Has(presignSignature)
+}
+
+// verifyPresigned verifies the presigned URL of the request against its method and constraints.
+// It returns the request with the principal that minted the URL, or writes the error response.
+func (m *Manager) verifyPresigned(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	query := r.URL.Query()
+
+	expected, err := hex.DecodeString(query.Get(presignSignature))
+	actual, _ := hex.DecodeString(m.presignSignature(r.URL.EscapedPath(), query))
+	if err != nil || !hmac.Equal(expected, actual) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return nil, false
+	}
+
+	expires, err := strconv.ParseInt(query.Get(presignExpires), 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		http.Error(w, "URL expired", http.StatusForbidden)
+		return nil, false
+	}
+
+	method := query.Get(presignMethod)
+	if r.Method != method && !(r.Method == http.MethodHead && method == http.MethodGet) {
+		http.Error(w, "Method not allowed by the URL", http.StatusForbidden)
+		return nil, false
+	}
+
+	if maxSize := query.Get(presignMaxSize); maxSize != "" {
+		limit, _ := strconv.ParseInt(maxSize, 10, 64)
+		if r.ContentLength > limit {
+			http.Error(w, "Content larger than allowed by the URL", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	if contentType := query.Get(presignContentType); contentType != "" && r.Header.Get("Content-Type") != contentType {
+		http.Error(w, "Content type not allowed by the URL", http.StatusForbidden)
+		return nil, false
+	}
+
+	if principal := query.Get(presignPrincipal); principal != "" {
+		r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{ID: principal, Method: "presigned"}))
+	}
+	return r, true
+}
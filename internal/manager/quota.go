@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+// ErrQuotaExceeded is returned for a write beyond the quota of its principal or namespace.
+var ErrQuotaExceeded = errors.New("Quota exceeded")
+
+// reserveQuota checks the write of an object of size bytes by the owner against the quotas of the owner
+// and of the namespace of the name, counting the writes in progress, and reserves it until release is
+// called. The stored size is not known before the upload, so the logical size is reserved for both.
+// A write that replaces the null version of the name, as in an unversioned namespace, or removes the
+// freed versions, as a rename its source, is only charged what it adds to the scopes they are counted in.
+func (m *Manager) reserveQuota(owner, name string, size int64, opts database.StoreOptions, freed ...*file.Info) (release func(), err error) {
+	scopes := (&file.Info{Name: name, Owner: owner}).UsageScopes()
+
+	if !opts.Versioned {
+		replaced, err := m.db.LoadVersion(name, file.NullVersion)
+		if err == nil {
+			freed = append(freed, replaced)
+		} else if !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	charges := make([]file.Usage, len(scopes))
+	for i, scope := range scopes {
+		charges[i] = file.Usage{Objects: 1, Bytes: size, StoredBytes: size}
+		for _, version := range freed {
+			if !slices.Contains(version.UsageScopes(), scope) {
+				continue
+			}
+			stored := version.StoredSize
+			if stored == 0 {
+				stored = version.Size
+			}
+			charges[i].Objects--
+			charges[i].Bytes -= version.Size
+			charges[i].StoredBytes -= stored
+		}
+	}
+
+	m.quotaLock.Lock()
+	defer m.quotaLock.Unlock()
+
+	for i, scope := range scopes {
+		quota, err := m.db.Quota(scope[0], scope[1])
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+
+		} else if err != nil {
+			return nil, err
+		}
+
+		usage, err := m.db.Usage(scope[0], scope[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if reserved, found := m.reserved[scope[0]+"/"+scope[1]]; found {
+			usage.Objects += reserved.Objects
+			usage.Bytes += reserved.Bytes
+			usage.StoredBytes += reserved.StoredBytes
+		}
+
+		usage.Objects += charges[i].Objects
+		usage.Bytes += charges[i].Bytes
+		usage.StoredBytes += charges[i].StoredBytes
+
+		if limit := quota.Exceeded(usage); limit != "" {
+			return nil, fmt.Errorf("%w: %s %s exceeds its %s", ErrQuotaExceeded, scope[0], scope[1], limit)
+		}
+	}
+
+	// what the write frees is not reserved, it only counts once the write is stored
+	for i := range charges {
+		charges[i].Bytes = max(charges[i].Bytes, 0)
+		charges[i].StoredBytes = max(charges[i].StoredBytes, 0)
+	}
+	m.reserve(scopes, charges, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.quotaLock.Lock()
+			defer m.quotaLock.Unlock()
+			m.reserve(scopes, charges, -1)
+		})
+	}, nil
+}
+
+// reserve adds the charges to the reservations of the scopes, or removes them if sign is negative.
+// The caller must hold the quota lock.
+func (m *Manager) reserve(scopes [][2]string, charges []file.Usage, sign int64) {
+	for i, scope := range scopes {
+		key := scope[0] + "/" + scope[1]
+
+		reserved, found := m.reserved[key]
+		if !found {
+			reserved = &file.Usage{Scope: scope[0], Name: scope[1]}
+			m.reserved[key] = reserved
+		}
+
+		reserved.Objects += sign * charges[i].Objects
+		reserved.Bytes += sign * charges[i].Bytes
+		reserved.StoredBytes += sign * charges[i].StoredBytes
+
+		if reserved.Objects <= 0 && reserved.Bytes <= 0 && reserved.StoredBytes <= 0 {
+			delete(m.reserved, key)
+		}
+	}
+}
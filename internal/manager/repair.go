@@ -0,0 +1,209 @@
+package manager
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"dcloud/internal/database"
+)
+
+// repairListed is the most queued contents the repair endpoint lists.
+const repairListed = 1000
+
+// writable reports whether new segments may be placed on the storage.
+func (s *Storage) writable() bool {
+	return !s.Draining && !s.Failed
+}
+
+// repairs copies the segments on failed and draining storages to the other storages in the background.
+func (m *Manager) repairs() {
+	ticker := time.NewTicker(repairInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.repairStorages(time.Now())
+	}
+}
+
+// repairStorages scans the content with segments on failed or draining storages and relocates
+// their segments, the most at risk content first.
+func (m *Manager) repairStorages(now time.Time) {
+	bad := m.failStorages(now)
+
+	storages := make([]string, 0, len(bad))
+	for storage := range bad {
+		storages = append(storages, storage)
+	}
+	slices.Sort(storages)
+
+	var queue []*RepairItem
+	if len(storages) > 0 {
+		var err error
+		if queue, err = m.scanRepairs(storages, bad); err != nil {
+			log.Printf("Repair: scan failed: %v", err)
+			return
+		}
+	}
+
+	m.repairLock.Lock()
+	m.repair = Repair{Scanned: now, Storages: storages, Total: len(queue), Copied: m.repair.Copied, Queue: queue}
+	m.repairLock.Unlock()
+
+	if len(queue) > 0 {
+		log.Printf("Repair: %d contents with segments on %s", len(queue), strings.Join(storages, ", "))
+	}
+
+	for _, item := range slices.Clone(queue) {
+		m.repairContent(item, bad)
+	}
+}
+
+// failStorages marks the storages that missed their heartbeats for longer than the timeout as failed, and the
+// ones that reported since as not failed. It returns the failed and draining storages, true for the failed ones.
+func (m *Manager) failStorages(now time.Time) map[string]bool {
+	m.Lock()
+	defer m.Unlock()
+
+	bad := make(map[string]bool)
+	for url, storage := range m.storages {
+		failed := now.Sub(storage.Heartbeat) > m.storageTimeout
+		if failed != storage.Failed {
+			log.Printf("Storage %s failed: %v (last heartbeat %s)", url, failed, storage.Heartbeat.Format(time.RFC3339))
+			storage.Failed = failed
+		}
+
+		if storage.Failed || storage.Draining {
+			bad[url] = storage.Failed
+		}
+	}
+	return bad
+}
+
+// scanRepairs lists the content with segments on the storages, the ones with the most segments
+// on failed storages first, since these may not be readable for long, then the ones with the most
+// segments on draining storages.
+func (m *Manager) scanRepairs(storages []string, bad map[string]bool) ([]*RepairItem, error) {
+	var (
+		queue []*RepairItem
+		after string
+	)
+	for {
+		list, err := m.db.SegmentsOn(storages, after, lifecycleBatch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, metadata := range list {
+			item := &RepairItem{Hash: metadata.Hash, Size: metadata.Stored(), Status: RepairQueued, metadata: metadata}
+			for _, segmentURL := range metadata.Segments() {
+				if failed, found := bad[storageURL(segmentURL)]; found {
+					item.Segments++
+					if failed {
+						item.Failed++
+					}
+				}
+			}
+			queue = append(queue, item)
+		}
+
+		if len(list) < lifecycleBatch {
+			break
+		}
+		after = list[len(list)-1].Hash
+	}
+
+	slices.SortStableFunc(queue, func(a, b *RepairItem) int {
+		if c := cmp.Compare(b.Failed, a.Failed); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Segments, a.Segments)
+	})
+	return queue, nil
+}
+
+// repairContent copies the segments of the queued content on failed and draining storages to writable
+// storages of the same class, and removes it from the queue once its segment URLs are replaced.
+// The segments of a storage that cannot be read make it unavailable until the next scan.
+func (m *Manager) repairContent(item *RepairItem, bad map[string]bool) {
+	m.setRepairStatus(item, RepairRunning, nil)
+
+	metadata := item.metadata
+	target := func(segmentURL string) func(*Storage) bool {
+		if _, found := bad[storageURL(segmentURL)]; !found {
+			return nil
+		}
+
+		class := m.classOf(segmentURL)
+		return func(storage *Storage) bool {
+			return class == "" || storage.Class == class
+		}
+	}
+
+	copied, err := m.relocate(metadata, target, func(segments, replicas []string) error {
+		return m.db.ReplaceSegments(metadata.Hash, metadata, segments, replicas)
+	})
+
+	// content changed or released meanwhile is scanned again if it still needs a repair
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		log.Printf("Repair: content %s: %v", metadata.Hash, err)
+		m.setRepairStatus(item, RepairUnavailable, err)
+		return
+	}
+
+	m.repairLock.Lock()
+	defer m.repairLock.Unlock()
+
+	m.repair.Queue = slices.DeleteFunc(m.repair.Queue, func(queued *RepairItem) bool {
+		return queued == item
+	})
+	m.repair.Copied += int64(copied)
+	if err == nil {
+		m.repair.Repaired++
+		log.Printf("Repair: content %s repaired, %d segments copied", metadata.Hash, item.Segments)
+	}
+}
+
+// setRepairStatus sets the status of the queued content and the error that made it unavailable.
+func (m *Manager) setRepairStatus(item *RepairItem, status string, err error) {
+	m.repairLock.Lock()
+	defer m.repairLock.Unlock()
+
+	item.Status = status
+	if err != nil {
+		item.Error = err.Error()
+		m.repair.Unavailable++
+	}
+}
+
+// repairStatus returns a copy of the repair progress with at most limit queued contents.
+func (m *Manager) repairStatus(limit int) Repair {
+	m.repairLock.Lock()
+	defer m.repairLock.Unlock()
+
+	status := m.repair
+	status.Queue = make([]*RepairItem, 0, min(limit, len(m.repair.Queue)))
+	for _, item := range m.repair.Queue[:min(limit, len(m.repair.Queue))] {
+		copied := *item
+		status.Queue = append(status.Queue, &copied)
+	}
+	if status.Storages == nil {
+		status.Storages = []string{}
+	}
+	return status
+}
+
+// drainStorage marks the storage as draining or not and reports whether it is registered.
+func (m *Manager) drainStorage(url string, draining bool) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	storage, found := m.storages[url]
+	if found {
+		storage.Draining = draining
+	}
+	return found
+}
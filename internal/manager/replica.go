@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"dcloud/internal/file"
+)
+
+// replicate copies every committed segment to the storages of the class, or of any class if it is empty,
+// until there are as many copies of it as the manager keeps, and commits the copies.
+// It returns the replicas, held until the caller releases them.
+func (m *Manager) replicate(segments []string, class string) (copies []*Scheme, err error) {
+	if m.replicas <= 1 {
+		return nil, nil
+	}
+
+	defer func() {
+		if err != nil {
+			go m.discardCopies(copies)
+		}
+	}()
+
+	accept := func(storage *Storage) bool {
+		return class == "" || storage.Class == class
+	}
+	for i, segmentURL := range segments {
+		holders := []string{storageURL(segmentURL)}
+		for range m.replicas - 1 {
+			var replica *Scheme
+			if replica, err = m.copySegment([]string{segmentURL}, m.copyAccept(accept, holders)); replica != nil {
+				copies = append(copies, replica)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("replica of segment %d: %w", i, err)
+			}
+			holders = append(holders, storageURL(replica.URL))
+		}
+	}
+
+	if err = m.commitScheme(copies); err != nil {
+		return nil, err
+	}
+	return copies, nil
+}
+
+// copyAccept returns the storages accept takes that a copy of a segment held by the holders, given by
+// their base URLs, may go to: the other storages, in other failure domains than the holders as long as
+// some writable storage is.
+func (m *Manager) copyAccept(accept func(*Storage) bool, holders []string) func(*Storage) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	level := m.spreading.level
+	domains := make(map[string]bool)
+	for _, holder := range holders {
+		if storage, found := m.storages[holder]; found {
+			domains[storage.domain(level)] = true
+		}
+	}
+
+	other := func(storage *Storage) bool {
+		return accept(storage) && !slices.Contains(holders, storage.URL)
+	}
+	apart := func(storage *Storage) bool {
+		return other(storage) && !domains[storage.domain(level)]
+	}
+
+	if level != "" {
+		for _, storage := range m.storages {
+			if storage.writable() && apart(storage) {
+				return apart
+			}
+		}
+	}
+	return other
+}
+
+// discardCopies rolls back the copies of segments, or deletes them if they were committed.
+func (m *Manager) discardCopies(copies []*Scheme) {
+	m.rollbackScheme(copies)
+	m.releaseSegments(copies)
+
+	var committed []string
+	for _, target := range copies {
+		if target.committed {
+			committed = append(committed, target.URL)
+		}
+	}
+	m.reclaim([]*file.Meta{{Metadata: committed}})
+}
+
+// retrieveSegment retrieves the first copy of a segment that can be read, the copies on
+// failed and draining storages last. It returns the response and the URL of the copy.
+func (m *Manager) retrieveSegment(copies []string) (*http.Response, string, error) {
+	err := errors.New("no copy of the segment")
+	for _, segmentURL := range m.readable(copies) {
+		chunkURL := strings.Replace(segmentURL, storedMark, "download", 1)
+
+		var resp *http.Response
+		if resp, err = m.retrieveChunk(chunkURL); err == nil {
+			return resp, chunkURL, nil
+		}
+		log.Printf("Error reading chunk %s: %v", chunkURL, err)
+	}
+	return nil, "", err
+}
+
+// readable orders the copies of a segment by their storages: the writable ones first,
+// then the failed and draining ones and last those not registered.
+func (m *Manager) readable(copies []string) []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	rank := func(segmentURL string) int {
+		storage := m.storageOf(segmentURL)
+		switch {
+		case storage == nil:
+			return 2
+		case !storage.writable():
+			return 1
+		}
+		return 0
+	}
+
+	ordered := slices.Clone(copies)
+	slices.SortStableFunc(ordered, func(a, b string) int {
+		return rank(a) - rank(b)
+	})
+	return ordered
+}
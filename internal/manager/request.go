@@ -5,17 +5,17 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+
+	"dcloud/internal/auth"
 )
 
 // storageRequest sends an HTTP request with the specified method, URL, and body.
 // It also sets additional headers if provided.
 func (m *Manager) storageRequest(method string, url string, body io.Reader, extra ...any) (*http.Response, error) {
-	client := &http.Client{Timeout: timeout}
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, err
@@ -32,31 +32,58 @@ func (m *Manager) storageRequest(method string, url string, body io.Reader, extr
 			req.Header.Set("X-Filename", val)
 		}
 	}
-	return client.Do(req)
+
+	if len(m.clusterSecret) > 0 {
+		auth.Sign(req, auth.ManagerPrincipal, m.clusterSecret)
+	}
+	return m.client.Do(req)
 }
 
 // commitScheme commits a scheme by sending a POST request to the commit URL.
 func (m *Manager) commitScheme(scheme []*Scheme) error {
 	for _, target := range scheme {
-		url := strings.Replace(target.URL, storedMark, "commit", 1)
-
-		resp, err := m.storageRequest(http.MethodPost, url, nil, target.Tmpfile)
-		if err != nil {
+		if err := m.commitChunk(target); err != nil {
 			return err
 		}
-		resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to commit chunk, status code: %d", resp.StatusCode)
-		}
 		log.Printf("Committed chunk: %s\n", target.URL)
 	}
 	return nil
 }
 
+// commitChunk commits the segment of the target, which the storage reports as committed from then on.
+// The segment is held until the caller releases it, see releaseSegments.
+func (m *Manager) commitChunk(target *Scheme) error {
+	unlock := m.lockSegment(target.URL)
+	defer unlock()
+
+	url := strings.Replace(target.URL, storedMark, "commit", 1)
+	done := m.changingStorage(url)
+	defer done()
+
+	resp, err := m.storageRequest(http.MethodPost, url, nil, target.Tmpfile)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to commit chunk, status code: %d", resp.StatusCode)
+	}
+	m.commitSegment(target)
+	m.holdSegment(target)
+	return nil
+}
+
 // rollbackScheme rolls back a schemes by sending a DELETE request to the rollback URL.
 func (m *Manager) rollbackScheme(scheme []*Scheme) {
 	for _, target := range scheme {
+		// committed segments are deleted instead, the space of the others is released
+		// whether they were uploaded or not
+		if target.committed {
+			continue
+		}
+		m.releaseSegment(target)
+
 		if target.Tmpfile == "" {
 			continue
 		}
@@ -70,7 +97,6 @@ func (m *Manager) rollbackScheme(scheme []*Scheme) {
 		resp.Body.Close()
 
 		log.Printf("Rollback chunk: %v\n", target.URL)
-		m.updateStorage(target, false)
 	}
 }
 
@@ -88,16 +114,13 @@ func (m *Manager) retrieveChunk(url string) (*http.Response, error) {
 	return resp, nil
 }
 
-// storeChunk stores a chunk by sending a PUT request with the chunk data.
+// storeChunk stores a chunk by sending a PUT request with the chunk data of the given size.
 // It also calculates and verifies the hash of the stored chunk.
-func (m *Manager) storeChunk(_ http.ResponseWriter, target *Scheme, hasher hash.Hash, body io.Reader) (storedHash, tmpFilePath string, err error) {
+func (m *Manager) storeChunk(_ http.ResponseWriter, target *Scheme, body io.Reader, size int64) (storedHash, tmpFilePath string, err error) {
 	segmentHasher := sha256.New()
+	teeReader := io.TeeReader(body, segmentHasher)
 
-	// Use MultiWriter to write to both hashers
-	multiWriter := io.MultiWriter(segmentHasher, hasher)
-	teeReader := io.TeeReader(body, multiWriter)
-
-	resp, err := m.storageRequest(http.MethodPut, target.URL + "/segment", teeReader, target.Size)
+	resp, err := m.storageRequest(http.MethodPut, target.URL + "/segment", teeReader, int(size))
 	if err != nil {
 		return "", "", err
 	}
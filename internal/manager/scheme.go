@@ -2,63 +2,52 @@ package manager
 
 import (
 	"errors"
+	"fmt"
 )
 
-// uploadScheme creates an uploading scheme for the given file size.
-func (m *Manager) uploadScheme(fileSize int) (scheme []*Scheme, err error) {
-    m.RLock()
-    defer m.RUnlock()
+// uploadScheme creates an uploading scheme for the file of the given size on the storages of the class,
+// or of any class if it is empty, with the placement policy of the file, and reserves its space on the storages.
+func (m *Manager) uploadScheme(filename, class string, fileSize int) (scheme []*Scheme, err error) {
+    policy, constraint, err := m.placementPolicy(filename)
+    if err != nil {
+        return nil, err
+    }
 
-    storagesCount := len(m.storages)
+    m.Lock()
+    defer m.Unlock()
 
-    if storagesCount == 0 {
+    if len(m.storages) == 0 {
         return nil, errors.New("no storages available")
     }
 
-    scheme = make([]*Scheme, 0, storagesCount)
-    storages := make([]*Storage, 0, storagesCount)
-
-    var totalPercent float64
+    storages := make([]*Storage, 0, len(m.storages))
     var totalAvailableSpace int
 
-    // Calculate available percent for each storage
     for _, storage := range m.storages {
-		if storage.Used >= storage.Limit {
+		if storage.Used >= storage.Limit || !storage.writable() || class != "" && storage.Class != class {
 			continue
 		}
-        storage.availablePercent = 100 - (float64(storage.Used) / float64(storage.Limit) * 100)
-        totalPercent += storage.availablePercent
         storages = append(storages, storage)
-
         totalAvailableSpace += storage.Limit - storage.Used
     }
 
-    if totalAvailableSpace < fileSize {
-        return nil, errors.New("not enough space available in storages")
+    if class != "" && len(storages) == 0 {
+        return nil, fmt.Errorf("no storage of class %s with space left", class)
     }
 
-    // Calculate proportions for each storage
-    assigned := 0
-    for _, storage := range storages {
-        storage.proportion = int(float64(fileSize) * (storage.availablePercent / totalPercent))
-        storage.fractional = float64(fileSize) * (storage.availablePercent / totalPercent) - float64(storage.proportion)
-        assigned += storage.proportion
+    if totalAvailableSpace < fileSize {
+        return nil, errors.New("not enough space available in storages")
     }
 
-    // Distribute the remaining bytes to the storages
-    remainder := fileSize - assigned
-    for i := 0; i < remainder; i++ {
-        storages[i % storagesCount].proportion++
+    if storages, err = constraint.candidates(fileSize, storages); err != nil {
+        return nil, err
     }
 
-    for _, storage := range storages {
-        if storage.proportion > 0 {
-            scheme = append(scheme, &Scheme{
-                URL:  storage.URL,
-                Size: storage.proportion,
-            })
-			storage.Used += storage.proportion // if rollback, this will be reverted
-        }
+    scheme = policy.Place(filename, fileSize, storages)
+    for _, target := range scheme {
+        storage := m.storages[target.URL]
+        storage.Used += target.Size // if rollback, this will be reverted
+        storage.pending += target.Size
     }
     return scheme, nil
 }
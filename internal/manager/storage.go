@@ -7,34 +7,128 @@ import (
 	"time"
 )
 
-// addStorage adds a new storage to the manager.
+// addStorage adds a new storage to the manager. A failed storage that was restarted registers again,
+// and stays draining if it was.
 func (m *Manager) addStorage(url string, storage *Storage) error {
 	m.Lock()
 	defer m.Unlock()
 
-	if _, found := m.storages[url]; found {
-		return errors.New("storage already registered")
+	if registered, found := m.storages[url]; found {
+		if !registered.Failed {
+			return errors.New("storage already registered")
+		}
+		storage.Draining = registered.Draining
+		log.Printf("Failed storage %s registers again", url)
 	}
 
 	storage.Registered = time.Now()
+	storage.Heartbeat = storage.Registered
 	m.storages[url] = storage
 	return nil
 }
 
-// updateStorage updates the storage usage.
-func (m *Manager) updateStorage(target *Scheme, commited bool) {
+// heartbeat updates the registered storage with its report and reports whether it is registered.
+// A report with the segments of the storage reconciles its usage.
+func (m *Manager) heartbeat(report *Storage, reconcile bool) bool {
 	m.Lock()
 	defer m.Unlock()
 
-	if !commited {
-		u, err := url.Parse(target.URL)
-		if err != nil {
-			log.Printf("updateStorage: Failed to parse URL: %v", err)
-			return
-		}
-		baseURL := u.Scheme + "://" + u.Host
-		if storage, found := m.storages[baseURL]; found {
-			storage.Used -= target.Size
-		}
+	storage, found := m.storages[report.URL]
+	if !found {
+		return false
+	}
+
+	storage.Limit = report.Limit
+	storage.Free = report.Free
+	storage.Heartbeat = time.Now()
+	if storage.Failed {
+		log.Printf("Storage %s failed: false (heartbeat)", storage.URL)
+		storage.Failed = false
+	}
+	if reconcile {
+		storage.reconcile(report)
+	}
+	return true
+}
+
+// reconcile corrects the usage of the storage to its report, which is authoritative for the committed
+// segments, and flags what the manager accounted differently. The space reserved for the segments
+// not committed yet is kept, since they may not have arrived on the storage. While segments are
+// committed or deleted the report may be behind, so the reconciliation waits for the next one.
+func (s *Storage) reconcile(report *Storage) {
+	s.Temporary = report.Temporary
+	if s.changes > 0 {
+		return
 	}
+
+	committed := report.Used - report.Temporary
+	drift, segmentDrift := s.Used-s.pending-committed, s.Segments-report.Segments
+	if drift != 0 || segmentDrift != 0 {
+		s.Drift, s.SegmentDrift, s.Drifted = drift, segmentDrift, s.Heartbeat
+		log.Printf("Storage %s usage drift: manager %d bytes in %d segments, storage %d bytes in %d segments",
+			s.URL, s.Used-s.pending, s.Segments, committed, report.Segments)
+	}
+
+	// the temporary segments of expired direct uploads stay on the storage without a reservation
+	s.Used = max(committed+s.pending, report.Used)
+	s.Segments = report.Segments
+}
+
+// storageOf returns the registered storage of the segment URL. The lock must be held.
+func (m *Manager) storageOf(segmentURL string) *Storage {
+	u, err := url.Parse(segmentURL)
+	if err != nil {
+		log.Printf("storageOf: Failed to parse URL: %v", err)
+		return nil
+	}
+	return m.storages[u.Scheme+"://"+u.Host]
+}
+
+// updateStorage changes the usage of the storage of the segment by used bytes, of which pending
+// are reserved for segments not committed yet, and by a number of committed segments.
+func (m *Manager) updateStorage(segmentURL string, used, pending, segments int) {
+	m.Lock()
+	defer m.Unlock()
+
+	if storage := m.storageOf(segmentURL); storage != nil {
+		storage.Used += used
+		storage.pending += pending
+		storage.Segments += segments
+	}
+}
+
+// changingStorage marks a commit or delete on the storage of the segment until the returned func is called.
+func (m *Manager) changingStorage(segmentURL string) func() {
+	m.Lock()
+	defer m.Unlock()
+
+	storage := m.storageOf(segmentURL)
+	if storage == nil {
+		return func() {}
+	}
+
+	storage.changes++
+	return func() {
+		m.Lock()
+		storage.changes--
+		m.Unlock()
+	}
+}
+
+// resizeSegment corrects the space reserved on the storage for the segment to the size it was stored with,
+// which differs from the reserved logical size once the segment is compressed or encrypted.
+func (m *Manager) resizeSegment(target *Scheme, size int) {
+	m.updateStorage(target.URL, size-target.Size, size-target.Size, 0)
+	target.Size = size
+}
+
+// releaseSegment releases the space reserved for a segment that was not committed.
+func (m *Manager) releaseSegment(target *Scheme) {
+	m.updateStorage(target.URL, -target.Size, -target.Size, 0)
+}
+
+// commitSegment accounts for a committed segment, whose space is no longer reserved.
+func (m *Manager) commitSegment(target *Scheme) {
+	target.committed = true
+	m.updateStorage(target.URL, 0, -target.Size, 1)
 }
@@ -0,0 +1,291 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"dcloud/internal/database"
+	"dcloud/internal/file"
+)
+
+// ErrClass is returned for an upload requesting an invalid storage class.
+var ErrClass = errors.New("invalid storage class")
+
+// readCount counts the reads of a content until they are written to the metadata store.
+type readCount struct {
+	count int64
+	last  time.Time
+}
+
+// validClass reports whether the storage class is a name of letters, digits, dashes and underscores.
+func validClass(class string) bool {
+	return class != "" && strings.Trim(class, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") == ""
+}
+
+// storageClass returns the storage class of the upload: the class of the X-Storage-Class header
+// or else the one of the namespace. Empty places the content on storages of any class.
+func (m *Manager) storageClass(r *http.Request, filename string) (string, error) {
+	if class := r.Header.Get("X-Storage-Class"); class != "" {
+		if !validClass(class) {
+			return "", fmt.Errorf("%w %q", ErrClass, class)
+		}
+		return class, nil
+	}
+
+	ns, err := m.db.Namespace(file.NamespaceOf(filename))
+	if err != nil {
+		return "", err
+	}
+	return ns.StorageClass, nil
+}
+
+// classStatus returns the HTTP status of an error of storageClass.
+func classStatus(err error) int {
+	if errors.Is(err, ErrClass) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// recordRead counts a read of the content for the transition rules.
+// The reads are written to the metadata store by the tiering job.
+func (m *Manager) recordRead(hash string) {
+	if hash == "" {
+		return
+	}
+
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+
+	counted, found := m.reads[hash]
+	if !found {
+		counted = &readCount{}
+		m.reads[hash] = counted
+	}
+	counted.count++
+	counted.last = time.Now()
+}
+
+// flushReads writes the counted reads to the metadata store.
+func (m *Manager) flushReads() {
+	m.readLock.Lock()
+	counted := m.reads
+	m.reads = make(map[string]*readCount)
+	m.readLock.Unlock()
+
+	for hash, read := range counted {
+		if err := m.db.Touch(hash, read.count, read.last); err != nil && !errors.Is(err, database.ErrNotFound) {
+			log.Printf("Tiering: reads of content %s: %v", hash, err)
+		}
+	}
+}
+
+// tiering moves content between the storage classes by the transition rules in the background.
+func (m *Manager) tiering() {
+	ticker := time.NewTicker(tieringInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.flushReads()
+
+		rules, err := m.db.Rules()
+		if err != nil {
+			log.Printf("Tiering: failed to load rules: %v", err)
+			continue
+		}
+
+		now := time.Now()
+		for _, rule := range rules {
+			if rule.StorageClass != "" {
+				m.transition(rule, now)
+			}
+		}
+	}
+}
+
+// transition moves the content the rule matches to the storages of its class,
+// until a batch only holds content that failed to move before.
+func (m *Manager) transition(rule *file.Rule, now time.Time) {
+	moved, failed := 0, make(map[string]bool)
+
+	for {
+		list, err := m.db.Transitions(rule, now, lifecycleBatch)
+		if err != nil {
+			log.Printf("Tiering rule %s: %v", rule.ID, err)
+			return
+		}
+
+		progress := 0
+		for _, metadata := range list {
+			if failed[metadata.Hash] {
+				continue
+			}
+
+			if err := m.moveContent(metadata, rule.StorageClass); err != nil {
+				log.Printf("Tiering rule %s: content %s: %v", rule.ID, metadata.Hash, err)
+				failed[metadata.Hash] = true
+				continue
+			}
+			progress++
+		}
+
+		moved += progress
+		if progress == 0 {
+			break
+		}
+	}
+
+	if moved > 0 || len(failed) > 0 {
+		log.Printf("Tiering rule %s: %d contents moved to %s, %d failed", rule.ID, moved, rule.StorageClass, len(failed))
+	}
+}
+
+// moveContent copies the segments of the content that are not on storages of the class to storages
+// of the class, replaces their URLs in the metadata store and deletes the old segments.
+func (m *Manager) moveContent(metadata *file.Meta, class string) error {
+	target := func(segmentURL string) func(*Storage) bool {
+		if m.classOf(segmentURL) == class {
+			return nil
+		}
+		return func(storage *Storage) bool {
+			return storage.Class == class
+		}
+	}
+
+	_, err := m.relocate(metadata, target, func(segments, replicas []string) error {
+		return m.db.MoveSegments(metadata.Hash, metadata, segments, replicas, class)
+	})
+	return err
+}
+
+// relocate copies the segments and replicas of the content for which target returns the storages they may go to,
+// to the one of those with the most space left that holds no other copy of the segment, replaces the segment
+// and replica URLs with replace and deletes the old ones. The segments are copied as they are stored, so
+// compressed and encrypted content stays so. It returns the bytes copied.
+func (m *Manager) relocate(metadata *file.Meta, target func(segmentURL string) func(*Storage) bool, replace func(segments, replicas []string) error) (copied int, err error) {
+	var (
+		all      = metadata.Segments()
+		segments = slices.Clone(all)
+		moved    []*Scheme
+	)
+	defer func() {
+		if err != nil {
+			go m.discardCopies(moved)
+		}
+	}()
+
+	for i, segmentURL := range all {
+		accept := target(segmentURL)
+		if accept == nil {
+			continue
+		}
+
+		// any copy of the segment is copied, and the new one goes to a storage without a copy
+		var holders []string
+		for _, copyURL := range file.Copies(segmentURL, segments) {
+			holders = append(holders, storageURL(copyURL))
+		}
+
+		var segment *Scheme
+		if segment, err = m.copySegment(file.Copies(segmentURL, all), m.copyAccept(accept, holders)); segment != nil {
+			moved = append(moved, segment)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("segment %d: %w", i, err)
+		}
+		segments[i] = segment.URL
+		copied += segment.Size
+	}
+
+	if err = m.commitScheme(moved); err != nil {
+		return 0, err
+	}
+
+	n := len(metadata.Metadata)
+	var replicas []string
+	if len(segments) > n {
+		replicas = segments[n:]
+	}
+	if err = replace(segments[:n], replicas); err != nil {
+		return 0, err
+	}
+	m.releaseSegments(moved)
+
+	// the old segments are deleted unless other content refers to them
+	go m.reclaim([]*file.Meta{metadata})
+	return copied, nil
+}
+
+// copySegment copies a stored segment, from the first of its copies that can be read, to a storage
+// accept takes. The target is returned once space is reserved for it, so it is rolled back if the copy fails.
+func (m *Manager) copySegment(copies []string, accept func(*Storage) bool) (*Scheme, error) {
+	resp, _, err := m.retrieveSegment(copies)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength < 0 {
+		return nil, errors.New("unknown segment size")
+	}
+
+	target, err := m.segmentTarget(accept, int(resp.ContentLength))
+	if err != nil {
+		return nil, err
+	}
+
+	storedHash, tmpFilePath, err := m.storeChunk(nil, target, resp.Body, resp.ContentLength)
+	if err != nil {
+		return target, err
+	}
+	target.Tmpfile = tmpFilePath
+
+	// the URL of a segment is named by the hash of what is stored, the copy has to match it
+	if storedHash != path.Base(copies[0]) {
+		return target, errors.New("hash mismatch")
+	}
+	target.URL += "/" + storedMark + "/" + storedHash
+	return target, nil
+}
+
+// segmentTarget reserves space for a segment of size bytes on the writable storage accept takes
+// with the most space left.
+func (m *Manager) segmentTarget(accept func(*Storage) bool, size int) (*Scheme, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var best *Storage
+	for _, storage := range m.storages {
+		if !storage.writable() || !accept(storage) || storage.Limit-storage.Used < size {
+			continue
+		}
+		if best == nil || storage.Limit-storage.Used > best.Limit-best.Used {
+			best = storage
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no storage to copy %d bytes to", size)
+	}
+
+	best.Used += size
+	best.pending += size
+	return &Scheme{URL: best.URL, Size: size}, nil
+}
+
+// classOf returns the storage class of the storage of the segment, empty if it is not registered.
+func (m *Manager) classOf(segmentURL string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	if storage := m.storageOf(segmentURL); storage != nil {
+		return storage.Class
+	}
+	return ""
+}
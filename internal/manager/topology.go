@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Failure domain levels of the topology of the storages, from the widest to the narrowest.
+const (
+	DomainZone = "zone"
+	DomainRack = "rack"
+	DomainHost = "host"
+)
+
+// ErrDomains is returned when a file cannot be placed on enough failure domains.
+var ErrDomains = errors.New("not enough failure domains")
+
+// ValidDomain reports whether the failure domain level is known; empty places the files without one.
+func ValidDomain(level string) bool {
+	switch level {
+	case "", DomainZone, DomainRack, DomainHost:
+		return true
+	}
+	return false
+}
+
+// parseTopology sets the failure domains of the storage from its labels, such as zone=eu-1a,rack=r12,host=node3.
+func parseTopology(labels string, storage *Storage) error {
+	for _, label := range strings.Split(labels, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(label), "=")
+		switch key {
+		case DomainZone:
+			storage.Zone = value
+		case DomainRack:
+			storage.Rack = value
+		case DomainHost:
+			storage.Host = value
+		case "":
+		default:
+			return fmt.Errorf("unknown topology label %q", key)
+		}
+	}
+	return nil
+}
+
+// domain returns the failure domain of the storage at the level. A domain lies within the one
+// of the level above it, so racks of the same name in two zones are distinct.
+func (s *Storage) domain(level string) string {
+	switch level {
+	case DomainZone:
+		return s.Zone
+	case DomainRack:
+		return s.Zone + "/" + s.Rack
+	}
+	return s.Zone + "/" + s.Rack + "/" + s.Host
+}
+
+// spreading is the failure domain constraint of a placement: the segments of a file are placed in
+// distinct domains of the level, and striped over at least min of them.
+type spreading struct {
+	level string
+	min   int
+}
+
+// candidates returns the storages a file of size bytes may be placed on under the constraint: the one with
+// the most space left in every domain, each offered at most its share of the file if the file must be striped.
+func (c spreading) candidates(size int, storages []*Storage) ([]*Storage, error) {
+	if c.level == "" {
+		return storages, nil
+	}
+
+	best := make(map[string]*Storage)
+	var domains []string
+	for _, storage := range storages {
+		domain := storage.domain(c.level)
+		if current, found := best[domain]; !found {
+			domains = append(domains, domain)
+			best[domain] = storage
+		} else if storage.Limit-storage.Used > current.Limit-current.Used {
+			best[domain] = storage
+		}
+	}
+
+	if len(domains) < c.min {
+		return nil, fmt.Errorf("%w: %d %s domains with space left, %d required", ErrDomains, len(domains), c.level, c.min)
+	}
+
+	// the share is offered through a copy of the storage with a lower limit, the placement
+	// policies only see the space left
+	share := size
+	if c.min > 1 {
+		share = (size + c.min - 1) / c.min
+	}
+
+	candidates := make([]*Storage, 0, len(domains))
+	available := 0
+	for _, domain := range domains {
+		storage := best[domain]
+		if storage.Limit-storage.Used > share {
+			offered := *storage
+			offered.Limit = storage.Used + share
+			storage = &offered
+		}
+		candidates = append(candidates, storage)
+		available += storage.Limit - storage.Used
+	}
+
+	if available < size {
+		return nil, fmt.Errorf("%w: %d bytes left on distinct %s domains for %d", ErrDomains, available, c.level, size)
+	}
+	return candidates, nil
+}
@@ -5,7 +5,9 @@ import (
 	"sync"
 	"time"
 
+	"dcloud/internal/crypt"
 	"dcloud/internal/database"
+	"dcloud/internal/file"
 )
 
 type Manager struct {
@@ -13,7 +15,50 @@ type Manager struct {
 	storages   map[string]*Storage
 
 	server     *http.Server
-	mongodb    *database.MongoDB
+	client     *http.Client
+	db         database.DB
+
+	// admins are the principals with full access regardless of their policies
+	admins map[string]bool
+
+	// clusterSecret signs the requests to the storages, if set
+	clusterSecret []byte
+
+	// presignSecret signs the presigned URLs
+	presignSecret []byte
+
+	// plans are the direct uploads waiting to be completed, by id
+	plans map[string]*uploadPlan
+
+	// keyring wraps the data keys of the encrypted content, nil without server-side encryption
+	keyring *crypt.Keyring
+
+	// placements are the placement policies by name, placement the name of the default one
+	// and spreading its failure domain constraint
+	placements map[string]Placement
+	placement  string
+	spreading  spreading
+
+	// replicas is the number of copies of every segment
+	replicas int
+
+	// reserved is the usage of the writes in progress by quota scope, guarded by quotaLock
+	quotaLock sync.Mutex
+	reserved  map[string]*file.Usage
+
+	// reads are the reads of the contents by hash not written to the metadata store yet, guarded by readLock
+	readLock sync.Mutex
+	reads    map[string]*readCount
+
+	// segmentLocks are the locks of the segment URLs being committed or reclaimed, guarded by segmentsLock
+	segmentsLock sync.Mutex
+	segmentLocks map[string]*segmentLock
+
+	// storageTimeout is how long a storage may miss its heartbeats before it is failed,
+	// repair the state of the repair, guarded by repairLock
+	storageTimeout time.Duration
+	repairLock     sync.Mutex
+	repair         Repair
 }
 
 type Storage struct {
@@ -22,6 +67,39 @@ type Storage struct {
 	URL            string
 	Registered     time.Time
 
+	// Free is the space available on the filesystem of the storage and Heartbeat
+	// the time of its last report, which also updates the limit.
+	Free           int
+	Heartbeat      time.Time
+
+	// Segments and Temporary are the committed segments and the bytes of the uncommitted ones
+	// the storage reported. Drift and SegmentDrift are what the manager had accounted beyond
+	// the report at the last reconciliation that differed, at Drifted.
+	Segments       int
+	Temporary      int
+	Drift          int
+	SegmentDrift   int
+	Drifted        time.Time
+
+	// Zone, Rack and Host are the failure domains of the storage from its topology labels;
+	// Host is the host of its URL unless it is labeled.
+	Zone           string `json:",omitempty"`
+	Rack           string `json:",omitempty"`
+	Host           string
+
+	// Class is the storage class the storage registered with, see the tiering.
+	Class          string `json:",omitempty"`
+
+	// Draining storages are being emptied by an admin, Failed ones missed their heartbeats:
+	// neither takes new segments and the repair copies their segments to the other storages.
+	Draining       bool `json:",omitempty"`
+	Failed         bool `json:",omitempty"`
+
+	// pending is the part of Used reserved for segments not committed yet, changes
+	// the number of commits and deletes in progress on the storage
+	pending          int
+	changes          int
+
 	free             int
 	availablePercent float64
 	fractional       float64
@@ -32,4 +110,72 @@ type Scheme struct {
     URL     string `json:"url"`
     Size    int    `json:"size"`
     Tmpfile string `json:"tmpfile"`
+
+    committed bool
+    held      bool
+}
+
+// Segment states reported by the stat endpoint.
+const (
+	SegmentOK          = "ok"
+	SegmentMissing     = "missing"
+	SegmentUnreachable = "unreachable"
+	SegmentError       = "error"
+)
+
+// Stat describes a file with the placement of its segments.
+type Stat struct {
+	file.Info
+	Segments []*SegmentStat `json:"segments"`
+	Replicas []*SegmentStat `json:"replicas,omitempty"`
+}
+
+// SegmentStat describes a segment and the state of its storage.
+type SegmentStat struct {
+	Index       int    `json:"index"`
+	URL         string `json:"url"`
+	Storage     string `json:"storage"`
+	Hash        string `json:"hash"`
+	Size        int64  `json:"size"`
+	LogicalSize int64  `json:"logicalSize,omitempty"`
+	Registered  bool   `json:"registered"`
+	Status      string `json:"status"`
+}
+
+// Repair states of the content in the repair queue.
+const (
+	RepairQueued      = "queued"
+	RepairRunning     = "repairing"
+	RepairUnavailable = "unavailable"
+)
+
+// Repair is the progress of the repair of the content with segments on failed and draining storages.
+type Repair struct {
+	// Scanned is when the last scan started, Storages the failed and draining storages it found,
+	// Total the contents it queued and Repaired and Unavailable how many of them were repaired
+	// or could not be; the unavailable ones are tried again on the next scan.
+	Scanned     time.Time `json:"scanned"`
+	Storages    []string  `json:"storages"`
+	Total       int       `json:"total"`
+	Repaired    int       `json:"repaired"`
+	Unavailable int       `json:"unavailable"`
+
+	// Copied is the bytes copied by the repair since the manager started.
+	Copied int64 `json:"copiedBytes"`
+
+	// Queue is the contents not repaired yet, the most at risk first.
+	Queue []*RepairItem `json:"queue"`
+}
+
+// RepairItem is content in the repair queue: Segments of its segments are on failed or draining
+// storages, Failed of them on failed ones.
+type RepairItem struct {
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Segments int    `json:"segments"`
+	Failed   int    `json:"failedSegments"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+
+	metadata *file.Meta
 }
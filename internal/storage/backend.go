@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("segment not found")
+
+// Backend is the place where a storage node keeps its segments.
+//
+// Segments are written to a temporary object first and become visible
+// under their final name only after Commit, so the manager can roll back
+// partially uploaded files.
+type Backend interface {
+	// PutTemp stores r as a new temporary object and returns its id and size.
+	PutTemp(r io.Reader) (tmp string, size int64, err error)
+
+	// Commit makes the temporary object visible under name.
+	Commit(tmp, name string) error
+
+	// Rollback removes the temporary object and returns its size.
+	Rollback(tmp string) (size int64, err error)
+
+	// Get opens the segment with the given name.
+	Get(name string) (io.ReadSeekCloser, *SegmentInfo, error)
+
+	// Delete removes the segment with the given name and returns its size.
+	Delete(name string) (size int64, err error)
+
+	// Stat returns the segment information without opening it.
+	Stat(name string) (*SegmentInfo, error)
+
+	// List calls fn for every committed segment.
+	List(fn func(*SegmentInfo) error) error
+}
+
+// SegmentInfo describes a committed segment.
+type SegmentInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// OpenBackend returns the backend described by dir.
+// "memory://" selects the in-memory backend, anything else is a local directory.
+func OpenBackend(dir string) (Backend, error) {
+	switch {
+	case strings.HasPrefix(dir, "memory://"):
+		return NewMemory(), nil
+
+	case strings.HasPrefix(dir, "file://"):
+		return NewLocal(strings.TrimPrefix(dir, "file://"))
+	}
+	return NewLocal(dir)
+}
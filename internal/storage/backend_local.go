@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local keeps segments as plain files in a directory.
+type Local struct {
+	Dir string
+}
+
+// NewLocal creates the directory if needed and removes temporary files left by a previous run.
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+	return &Local{Dir: dir}, nil
+}
+
+// PutTemp writes r to a new temporary file and returns its path.
+func (l *Local) PutTemp(r io.Reader) (string, int64, error) {
+	tmpFile, err := os.CreateTemp(l.Dir, "*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+
+	size, err := io.Copy(tmpFile, r)
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", 0, err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", 0, err
+	}
+	return tmpFile.Name(), size, nil
+}
+
+// Commit renames the temporary file to its final name.
+func (l *Local) Commit(tmp, name string) error {
+	tmpPath, err := l.tmpPath(tmp)
+	if err != nil {
+		return err
+	}
+
+	filePath, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// Rollback removes the temporary file.
+func (l *Local) Rollback(tmp string) (int64, error) {
+	tmpPath, err := l.tmpPath(tmp)
+	if err != nil {
+		return 0, err
+	}
+	return l.remove(tmpPath)
+}
+
+// Get opens the segment file.
+func (l *Local) Get(name string) (io.ReadSeekCloser, *SegmentInfo, error) {
+	filePath, err := l.path(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, notFound(err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, segmentInfo(info), nil
+}
+
+// Delete removes the segment file.
+func (l *Local) Delete(name string) (int64, error) {
+	filePath, err := l.path(name)
+	if err != nil {
+		return 0, err
+	}
+	return l.remove(filePath)
+}
+
+// Stat returns the segment file information.
+func (l *Local) Stat(name string) (*SegmentInfo, error) {
+	filePath, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return segmentInfo(info), nil
+}
+
+// List walks over the committed segment files.
+func (l *Local) List(fn func(*SegmentInfo) error) error {
+	entries, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		if err = fn(segmentInfo(info)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the path of the segment, refusing names that escape the directory.
+func (l *Local) path(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.HasSuffix(name, ".tmp") {
+		return "", errors.New("invalid segment name")
+	}
+	return filepath.Join(l.Dir, name), nil
+}
+
+// tmpPath checks that tmp is a temporary file of this directory.
+func (l *Local) tmpPath(tmp string) (string, error) {
+	if filepath.Dir(tmp) != filepath.Clean(l.Dir) || !strings.HasSuffix(tmp, ".tmp") {
+		return "", errors.New("invalid temporary filename")
+	}
+	return tmp, nil
+}
+
+func (l *Local) remove(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, notFound(err)
+	}
+
+	if err = os.Remove(path); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func segmentInfo(info os.FileInfo) *SegmentInfo {
+	return &SegmentInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+}
+
+func notFound(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Memory keeps segments in memory. It is meant for tests and throwaway clusters.
+type Memory struct {
+	sync.RWMutex
+	segments map[string]*memorySegment
+	tmp      map[string][]byte
+	seq      int
+}
+
+type memorySegment struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory creates an empty in-memory backend.
+func NewMemory() *Memory {
+	return &Memory{
+		segments: make(map[string]*memorySegment),
+		tmp:      make(map[string][]byte),
+	}
+}
+
+// PutTemp reads r into a new temporary buffer.
+func (m *Memory) PutTemp(r io.Reader) (string, int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.seq++
+	tmp := strconv.Itoa(m.seq) + ".tmp"
+	m.tmp[tmp] = data
+	return tmp, int64(len(data)), nil
+}
+
+// Commit moves the temporary buffer under its final name.
+func (m *Memory) Commit(tmp, name string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	data, found := m.tmp[tmp]
+	if !found {
+		return ErrNotFound
+	}
+	delete(m.tmp, tmp)
+
+	m.segments[name] = &memorySegment{data: data, modTime: time.Now()}
+	return nil
+}
+
+// Rollback drops the temporary buffer.
+func (m *Memory) Rollback(tmp string) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	data, found := m.tmp[tmp]
+	if !found {
+		return 0, ErrNotFound
+	}
+	delete(m.tmp, tmp)
+	return int64(len(data)), nil
+}
+
+// Get returns a reader over the segment.
+func (m *Memory) Get(name string) (io.ReadSeekCloser, *SegmentInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	segment, found := m.segments[name]
+	if !found {
+		return nil, nil, ErrNotFound
+	}
+	return nopCloser{bytes.NewReader(segment.data)}, segment.info(name), nil
+}
+
+// Delete drops the segment.
+func (m *Memory) Delete(name string) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	segment, found := m.segments[name]
+	if !found {
+		return 0, ErrNotFound
+	}
+	delete(m.segments, name)
+	return int64(len(segment.data)), nil
+}
+
+// Stat returns the segment information.
+func (m *Memory) Stat(name string) (*SegmentInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	segment, found := m.segments[name]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return segment.info(name), nil
+}
+
+// List calls fn for every segment.
+func (m *Memory) List(fn func(*SegmentInfo) error) error {
+	m.RLock()
+	infos := make([]*SegmentInfo, 0, len(m.segments))
+	for name, segment := range m.segments {
+		infos = append(infos, segment.info(name))
+	}
+	m.RUnlock()
+
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memorySegment) info(name string) *SegmentInfo {
+	return &SegmentInfo{
+		Name:    name,
+		Size:    int64(len(s.data)),
+		ModTime: s.modTime,
+	}
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMemoryLimit is the limit of the memory backend if none is configured
+	defaultMemoryLimit = 10 * 1024 * 1024 * 1024 // 10 GB
+
+	// defaultHeartbeat is the interval of the reports to the manager
+	defaultHeartbeat = 30 * time.Second
+
+	// defaultCapacity and defaultHeadroom apply to a directory if they are not configured
+	defaultCapacity = "100%"
+	defaultHeadroom = "5%"
+)
+
+// amount is a configured number of bytes, absolute or a percentage of the filesystem.
+type amount struct {
+	bytes   int64
+	percent float64
+}
+
+// parseAmount parses a number of bytes with an optional K, M, G or T suffix (powers of 1024),
+// or a percentage of the filesystem such as 90%.
+func parseAmount(value string) (amount, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	if number, found := strings.CutSuffix(value, "%"); found {
+		percent, err := strconv.ParseFloat(number, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return amount{}, fmt.Errorf("invalid percentage %q", value)
+		}
+		return amount{percent: percent}, nil
+	}
+
+	value = strings.TrimSuffix(value, "B")
+	multiplier := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if number, found := strings.CutSuffix(value, suffix); found {
+			value = number
+			multiplier = 1 << (10 * (i + 1))
+			break
+		}
+	}
+
+	bytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || bytes < 0 {
+		return amount{}, fmt.Errorf("invalid size %q", value)
+	}
+	return amount{bytes: bytes * multiplier}, nil
+}
+
+// of returns the amount for a filesystem of total bytes.
+func (a amount) of(total int64) int64 {
+	if a.percent > 0 {
+		return int64(float64(total) * a.percent / 100)
+	}
+	return a.bytes
+}
+
+// initCapacity parses the configured capacity and headroom and computes the first limit.
+func (s *Storage) initCapacity() (err error) {
+	capacity, headroom := s.Capacity, s.Headroom
+
+	if s.localDir() == "" {
+		if capacity == "" {
+			capacity = strconv.FormatInt(defaultMemoryLimit, 10)
+		}
+		if s.capacity, err = parseAmount(capacity); err != nil {
+			return err
+		}
+		if s.capacity.percent > 0 {
+			return errors.New("a percentage capacity needs a directory backend")
+		}
+		return s.refreshLimit()
+	}
+
+	if capacity == "" {
+		capacity = defaultCapacity
+	}
+	if headroom == "" {
+		headroom = defaultHeadroom
+	}
+
+	if s.capacity, err = parseAmount(capacity); err != nil {
+		return fmt.Errorf("capacity: %w", err)
+	}
+	if s.headroom, err = parseAmount(headroom); err != nil {
+		return fmt.Errorf("headroom: %w", err)
+	}
+	return s.refreshLimit()
+}
+
+// refreshLimit updates the limit of the storage: the configured capacity, reduced to what the
+// storage holds and what is free on its filesystem beyond the headroom, so the limit follows
+// disks filled by other processes. Without a filesystem the limit is the configured capacity.
+func (s *Storage) refreshLimit() error {
+	dir := s.localDir()
+	if dir == "" {
+		atomic.StoreInt64(&s.Limit, s.capacity.bytes)
+		atomic.StoreInt64(&s.Free, s.capacity.bytes-atomic.LoadInt64(&s.Used))
+		return nil
+	}
+
+	total, available, err := diskSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) && s.capacity.percent == 0 {
+		// the space of the filesystem is unknown on this platform, the capacity is trusted
+		atomic.StoreInt64(&s.Limit, s.capacity.bytes)
+		atomic.StoreInt64(&s.Free, s.capacity.bytes-atomic.LoadInt64(&s.Used))
+		return nil
+
+	} else if err != nil {
+		return fmt.Errorf("filesystem of %s: %w", dir, err)
+	}
+
+	limit := s.capacity.of(total)
+	if usable := atomic.LoadInt64(&s.Used) + available - s.headroom.of(total); usable < limit {
+		limit = max(usable, 0)
+	}
+
+	atomic.StoreInt64(&s.Limit, limit)
+	atomic.StoreInt64(&s.Free, available)
+	return nil
+}
+
+// localDir returns the directory of the segments, empty for the memory backend.
+func (s *Storage) localDir() string {
+	if local, ok := s.backend.(*Local); ok {
+		return local.Dir
+	}
+	return ""
+}
@@ -4,20 +4,21 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
+
+	"dcloud/internal/auth"
+	"dcloud/internal/certs"
 )
 
 // New creates a new Storage instance, initializes it, and sets up HTTP handlers.
 func New(addr, dir, url string) (s *Storage, err error) {
 	s = &Storage{
-		Limit: 10 * 1024 * 1024 * 1024, // 10 GB
 		Addr:  addr,
 		Dir:   dir,
 		RegisterURL: url,
+		Heartbeat: defaultHeartbeat,
 	}
 
 	if err = s.initStorage(); err != nil {
@@ -28,6 +29,7 @@ func New(addr, dir, url string) (s *Storage, err error) {
 	mux.HandleFunc("/", s.routeHandler)
 	mux.HandleFunc("/rollback/", s.rollbackHandler)
 	mux.HandleFunc("/commit/", s.commitHandler)
+	mux.HandleFunc("/delete/", s.deleteHandler)
 
 	s.server = &http.Server{
 		Addr:    s.Addr,
@@ -36,25 +38,17 @@ func New(addr, dir, url string) (s *Storage, err error) {
 	return s, nil
 }
 
-// initStorage initializes the storage directory and calculates the used space.
+// initStorage opens the storage backend and calculates the used space.
 func (s *Storage) initStorage() (err error){
-	var total int64
+	var total, segments int64
 
-	if err = os.MkdirAll(s.Dir, 0755); err != nil {
+	if s.backend, err = OpenBackend(s.Dir); err != nil {
 		return err
 	}
 
-	err = filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			if strings.HasSuffix(info.Name(), ".tmp") {
-				os.Remove(path)
-				return nil
-			}
-			total += info.Size()
-		}
+	err = s.backend.List(func(info *SegmentInfo) error {
+		total += info.Size
+		segments++
 		return nil
 	})
 
@@ -63,17 +57,18 @@ func (s *Storage) initStorage() (err error){
 	}
 
 	s.Used = total
-
-	if s.Used > s.Limit {
-		s.Used = s.Limit
-	}
+	s.Segments = segments
+	s.temps = make(map[string]int64)
+	s.uploads = make(map[string]*directUpload)
 	return nil
 }
 
 // register sends a registration request to the specified URL with storage details.
-func (s *Storage) register() error {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+// A heartbeat repeats it to report the current limit and usage.
+func (s *Storage) register(heartbeat bool) error {
+	client, err := certs.Client(s.ManagerCA, 5*time.Second)
+	if err != nil {
+		return err
 	}
 
 	req, err := http.NewRequest(http.MethodConnect, s.RegisterURL, nil)
@@ -83,8 +78,33 @@ func (s *Storage) register() error {
 
 	req.Header.Set("X-Register", "true")
 	req.Header.Set("X-Addr", s.Addr)
-	req.Header.Set("X-Limit", strconv.FormatInt(s.Limit, 10))
-	req.Header.Set("X-Used", strconv.FormatInt(s.Used, 10))
+	req.Header.Set("X-Limit", strconv.FormatInt(atomic.LoadInt64(&s.Limit), 10))
+	req.Header.Set("X-Used", strconv.FormatInt(atomic.LoadInt64(&s.Used), 10))
+	req.Header.Set("X-Free", strconv.FormatInt(atomic.LoadInt64(&s.Free), 10))
+	req.Header.Set("X-Segments", strconv.FormatInt(atomic.LoadInt64(&s.Segments), 10))
+	req.Header.Set("X-Temporary", strconv.FormatInt(atomic.LoadInt64(&s.Temporary), 10))
+	req.Header.Set("X-Interval", s.Heartbeat.String())
+	if heartbeat {
+		req.Header.Set("X-Heartbeat", "true")
+	}
+	if s.TLSCert != "" {
+		req.Header.Set("X-Scheme", "https")
+	}
+	if s.Host != "" {
+		req.Header.Set("X-Host", s.Host)
+	}
+	if s.Topology != "" {
+		req.Header.Set("X-Topology", s.Topology)
+	}
+	if s.Class != "" {
+		req.Header.Set("X-Class", s.Class)
+	}
+	if s.APIKey != "" {
+		req.Header.Set(auth.APIKeyHeader, s.APIKey)
+	}
+	if s.ClusterSecret != "" {
+		auth.Sign(req, auth.StoragePrincipal, []byte(s.ClusterSecret))
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -98,9 +118,56 @@ func (s *Storage) register() error {
 	return nil
 }
 
+// heartbeat reports the limit and usage of the storage to the manager at every interval,
+// counted again from the backend unless segments were changed meanwhile.
+func (s *Storage) heartbeat() {
+	ticker := time.NewTicker(s.Heartbeat)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.reconcile(); err != nil {
+			log.Printf("Storage %s reconcile: %v", s.Addr, err)
+		}
+
+		if err := s.refreshLimit(); err != nil {
+			log.Printf("Storage %s capacity: %v", s.Addr, err)
+		}
+
+		if err := s.register(true); err != nil {
+			log.Printf("Storage %s heartbeat: %v", s.Addr, err)
+		}
+	}
+}
+
 // Start begins the HTTP server and registers the storage.
 func (s *Storage) Start() (err error) {
+	if err = s.initCapacity(); err != nil {
+		return err
+	}
+	log.Printf("Storage %s limit: %d used: %d", s.Addr, atomic.LoadInt64(&s.Limit), atomic.LoadInt64(&s.Used))
+
+	if s.ClusterSecret != "" {
+		// clients transfer segments directly with the URLs the manager signed for them
+		s.server.Handler = auth.Middleware(auth.Chain{
+			auth.NewHMACKeys(map[string]string{auth.ManagerPrincipal: s.ClusterSecret}).RejectReplays(),
+			auth.NewTokens(s.ClusterSecret),
+		}, s.server.Handler)
+		go s.sweeper()
+	}
+
+	if s.TLSCert != "" || s.TLSKey != "" {
+		reloader, err := certs.NewReloader(s.TLSCert, s.TLSKey)
+		if err != nil {
+			return fmt.Errorf("TLS certificate: %w", err)
+		}
+		s.server.TLSConfig = reloader.TLSConfig()
+	}
+
 	go func () {
+		if s.server.TLSConfig != nil {
+			err = s.server.ListenAndServeTLS("", "")
+			return
+		}
 		err = s.server.ListenAndServe()
 	}()
 
@@ -110,9 +177,13 @@ func (s *Storage) Start() (err error) {
 		return err
 	}
 
-	if err = s.register(); err != nil {
+	if err = s.register(false); err != nil {
 		return err
 	}
 	log.Printf("Storage %s successfully registered", s.Addr)
+
+	if s.Heartbeat > 0 {
+		go s.heartbeat()
+	}
 	return nil
 }
\ No newline at end of file
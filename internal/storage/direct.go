@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dcloud/internal/auth"
+)
+
+const (
+	// sweepInterval is the interval of the removal of the expired direct uploads
+	sweepInterval = time.Minute
+
+	// sweepGrace is how long the temporary segment of a direct upload is kept after its URL expired,
+	// for the manager to commit an upload completed just in time
+	sweepGrace = time.Minute
+)
+
+// directUpload is a segment a client uploads with a URL the manager signed for it.
+type directUpload struct {
+	tmp     string
+	expires time.Time
+}
+
+// uploadID returns the upload a segment URL is signed for, /segment/<plan>-<index>,
+// or empty for /segment.
+func uploadID(path string) (string, bool) {
+	if path == "/segment" {
+		return "", true
+	}
+	id, found := strings.CutPrefix(path, "/segment/")
+	return id, found && id != "" && !strings.Contains(id, "/")
+}
+
+// claimUpload takes the signed upload id for the request, so that its URL is not used twice.
+// It reports false if the URL was used already.
+func (s *Storage) claimUpload(id string, r *http.Request) bool {
+	expires, _ := strconv.ParseInt(r.URL.Query().Get(auth.TokenExpiresParam), 10, 64)
+
+	s.accounting.Lock()
+	defer s.accounting.Unlock()
+
+	if _, used := s.uploads[id]; used {
+		return false
+	}
+	s.uploads[id] = &directUpload{expires: time.Unix(expires, 0)}
+	return true
+}
+
+// releaseUpload gives the upload id back after a failed upload, so the client may try again.
+func (s *Storage) releaseUpload(id string) {
+	s.accounting.Lock()
+	defer s.accounting.Unlock()
+
+	delete(s.uploads, id)
+}
+
+// uploaded records the temporary segment of the upload id.
+func (s *Storage) uploaded(id, tmp string) {
+	s.accounting.Lock()
+	defer s.accounting.Unlock()
+
+	if upload, found := s.uploads[id]; found {
+		upload.tmp = tmp
+	}
+}
+
+// sweepUploads forgets the direct uploads expired before now and removes their temporary segments
+// the manager did not commit or roll back: the client never completed the upload.
+func (s *Storage) sweepUploads(now time.Time) {
+	s.accounting.Lock()
+	var unclaimed []string
+	for id, upload := range s.uploads {
+		// an upload still being received is swept once it is recorded
+		if upload.tmp == "" || now.Before(upload.expires.Add(sweepGrace)) {
+			continue
+		}
+		if _, pending := s.temps[upload.tmp]; pending {
+			unclaimed = append(unclaimed, upload.tmp)
+		}
+		delete(s.uploads, id)
+	}
+	s.accounting.Unlock()
+
+	for _, tmp := range unclaimed {
+		size, err := s.backend.Rollback(tmp)
+		if err != nil {
+			log.Printf("Storage %s sweep: %s: %v", s.Addr, tmp, err)
+			continue
+		}
+		log.Printf("Storage %s sweep: removed %s of an expired direct upload (%d bytes)", s.Addr, tmp, size)
+		s.rollbackTemp(tmp, size)
+	}
+}
+
+// sweeper removes the expired direct uploads at every interval.
+func (s *Storage) sweeper() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.sweepUploads(now)
+	}
+}
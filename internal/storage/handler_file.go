@@ -6,9 +6,11 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
 	"sync/atomic"
+
+	"dcloud/internal/auth"
 )
 
 // routeHandler routes the HTTP request to the appropriate handler based on the request method.
@@ -16,7 +18,7 @@ func (s *Storage) routeHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPut:
 		s.uploadHandler(w, r)
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 		s.downloadHandler(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -25,37 +27,62 @@ func (s *Storage) routeHandler(w http.ResponseWriter, r *http.Request) {
 
 // uploadHandler handles the upload of a file, calculates its SHA-256 hash, and stores it temporarily.
 func (s *Storage) uploadHandler(w http.ResponseWriter, r *http.Request) {
-	filename := filepath.Base(r.URL.Path)
-	if filename == "" || filename != "segment" {
+	id, valid := uploadID(r.URL.Path)
+	if !valid {
 		http.Error(w, "invalid filename", http.StatusBadRequest)
 		return
 	}
 
-	tmpFile, err := os.CreateTemp(s.Dir, "*.tmp")
-	if err != nil {
-		log.Printf("Storage %s uploadHandler create temp file error: %v", s.Addr, r.URL.Path)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// a direct upload of a client is signed for a single segment of a plan
+	principal := auth.FromContext(r.Context())
+	direct := principal != nil && principal.Method == "token"
+	if direct && id == "" {
+		http.Error(w, "Segment URL without an upload", http.StatusForbidden)
+		return
+	}
+
+	// the manager placed the segment by the last report, the disk may have filled since
+	if err := s.refreshLimit(); err != nil {
+		log.Printf("Storage %s capacity: %v", s.Addr, err)
+	}
+	if r.ContentLength > atomic.LoadInt64(&s.Limit)-atomic.LoadInt64(&s.Used) {
+		log.Printf("Storage %s uploadHandler: %s needs %d bytes, storage full", s.Addr, r.URL.Path, r.ContentLength)
+		http.Error(w, "Storage full", http.StatusInsufficientStorage)
+		return
+	}
+
+	if direct && !s.claimUpload(id, r) {
+		log.Printf("Storage %s uploadHandler: %s used again", s.Addr, r.URL.Path)
+		http.Error(w, "Segment URL already used", http.StatusConflict)
 		return
 	}
 
 	hasher := sha256.New()
-	_, err = io.Copy(tmpFile, io.TeeReader(r.Body, hasher))
-	if err != nil && err != io.EOF {
-		log.Printf("Storage %s uploadHandler: %s\n\tFILE: %s\n FAILED", s.Addr, r.URL.Path, tmpFile.Name())
+	tmpFile, size, err := s.backend.PutTemp(io.TeeReader(r.Body, hasher))
+	if err != nil {
+		if direct {
+			s.releaseUpload(id)
+		}
+		log.Printf("Storage %s uploadHandler: %s FAILED: %v", s.Addr, r.URL.Path, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
 		return
 	}
-	tmpFile.Close()
 
 	hash := hex.EncodeToString(hasher.Sum(nil))
-	log.Printf("Storage %s uploadHandler: %s\n\tFILE: %s\n\tHASH: %s OK", s.Addr, r.URL.Path, tmpFile.Name(), hash)
+	log.Printf("Storage %s uploadHandler: %s\n\tFILE: %s\n\tHASH: %s OK", s.Addr, r.URL.Path, tmpFile, hash)
 
 	w.Header().Set("X-Hash", hash)
-	w.Header().Set("X-Filename", tmpFile.Name())
+	w.Header().Set("X-Filename", tmpFile)
 
-	atomic.AddInt64(&s.Used, r.ContentLength)
+	// a direct upload of a client gets a receipt to hand over to the manager
+	if direct {
+		w.Header().Set("X-Receipt", auth.Receipt([]byte(s.ClusterSecret), principal.ID, hash, tmpFile, size))
+	}
+
+	s.addTemp(tmpFile, size)
+	if direct {
+		s.uploaded(id, tmpFile)
+	}
 }
 
 // downloadHandler serves the requested file from the storage directory.
@@ -68,6 +95,48 @@ func (s *Storage) downloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath := filepath.Join(s.Dir, filename)
-	http.ServeFile(w, r, filePath)
+	segment, info, err := s.backend.Get(filename)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer segment.Close()
+
+	http.ServeContent(w, r, filename, info.ModTime, segment)
+}
+
+// deleteHandler handles DELETE requests to remove a committed segment.
+func (s *Storage) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := filepath.Base(r.URL.Path)
+	if filename == "" {
+		http.Error(w, "filename is required", http.StatusBadRequest)
+		return
+	}
+
+	done := s.changing()
+	defer done()
+
+	size, err := s.backend.Delete(filename)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+
+	} else if err != nil {
+		log.Printf("Storage %s deleteHandler: %s error: %v", s.Addr, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Storage %s deleteHandler: %s\n\tREMOVE: %s size: %v", s.Addr, r.URL.Path, filename, size)
+	w.Header().Set("X-Size", strconv.FormatInt(size, 10))
+	s.deleteSegment(size)
 }
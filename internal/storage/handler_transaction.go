@@ -3,9 +3,7 @@ package storage
 import (
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
-	"sync/atomic"
 )
 
 // rollbackHandler handles DELETE requests to rollback a transaction by removing a specified file.
@@ -22,19 +20,15 @@ func (s *Storage) rollbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	info, err := os.Stat(filePath)
+	size, err := s.backend.Rollback(filePath)
 	if err != nil {
+		log.Printf("Storage %s rollbackHandler: %s\n\tREMOVE: %v error: %v", s.Addr, r.URL.Path, filePath, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	size := info.Size()
 
 	log.Printf("Storage %s rollbackHandler: %s\n\tREMOVE: %v size: %v", s.Addr, r.URL.Path, filePath, size)
-	if err := os.Remove(filePath); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	atomic.AddInt64(&s.Used, -size)
+	s.rollbackTemp(filePath, size)
 }
 
 // commitHandler handles POST requests to commit a transaction by renaming a temporary file to its final destination.
@@ -52,18 +46,16 @@ func (s *Storage) commitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath := filepath.Join(s.Dir, file)
+	done := s.changing()
+	defer done()
 
-	log.Printf("Storage %s commitHandler: %s\n\tFILE: %s\n\tRENAME: %s", s.Addr, r.URL.Path, tmpFilePath, filePath)
-	if err := os.Rename(tmpFilePath, filePath); err != nil {
+	// the same content committed twice replaces the segment, which is counted once
+	replaced, _ := s.backend.Stat(file)
+
+	log.Printf("Storage %s commitHandler: %s\n\tFILE: %s\n\tRENAME: %s", s.Addr, r.URL.Path, tmpFilePath, file)
+	if err := s.backend.Commit(tmpFilePath, file); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.commitTemp(tmpFilePath, replaced)
 }
-
-
-
-
-
-
-
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || dragonfly || windows)
+
+package storage
+
+import "errors"
+
+// diskSpace is not available on this platform; the capacity must be configured in bytes.
+func diskSpace(dir string) (total, available int64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
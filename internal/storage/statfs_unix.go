@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package storage
+
+import "syscall"
+
+// diskSpace returns the size of the filesystem holding dir and the bytes available on it to unprivileged users.
+func diskSpace(dir string) (total, available int64, err error) {
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+	return int64(stat.Blocks) * int64(stat.Bsize), int64(stat.Bavail) * int64(stat.Bsize), nil
+}
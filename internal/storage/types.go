@@ -2,17 +2,70 @@ package storage
 
 import (
 	"net/http"
+	"sync"
 	"time"
 )
 
 type Storage struct {
+	// Limit is what the storage may hold, see refreshLimit; Used is what it holds
+	// and Free the space available on its filesystem. Used counts the Segments and the
+	// Temporary bytes of the segments not committed yet, see reconcile.
 	Limit       int64
 	Used        int64
+	Free        int64
+	Segments    int64
+	Temporary   int64
 	Addr        string
 	Dir         string
 	RegisterURL string
+	APIKey      string
+
+	// ClusterSecret, if set, is shared with the manager: the storage signs its registration
+	// with it and accepts only requests signed by the manager.
+	ClusterSecret string
+
+	// TLSCert and TLSKey, if set, are the files of the certificate the storage is served with over TLS.
+	// They are reloaded when the files change. Host is the name in the certificate the manager
+	// uses in the storage URL instead of the address the storage registers from.
+	TLSCert string
+	TLSKey  string
+	Host    string
+
+	// ManagerCA is the file of the CA certificates trusted for an HTTPS registration URL;
+	// the system roots are used if it is not set.
+	ManagerCA string
+
+	// Capacity is the most the storage holds and Headroom what it leaves free on its filesystem:
+	// bytes with an optional K, M, G or T suffix, or a percentage of the filesystem.
+	// They default to 100% and 5% for a directory; the memory backend holds 10 GB.
+	Capacity string
+	Headroom string
+
+	// Heartbeat is the interval of the reports of the limit and usage to the manager.
+	Heartbeat time.Duration
+
+	// Topology labels the failure domains of the storage for the placement of the manager,
+	// such as zone=eu-1a,rack=r12,host=node3.
+	Topology string
+
+	// Class is the storage class of the storage, such as fast or bulk: uploads and the transition
+	// rules of the manager select the storages by it.
+	Class string
+
 	Registered  time.Time
 
-	server *http.Server
+	capacity amount
+	headroom amount
+
+	// accounting guards temps, the sizes of the temporary segments, and the updates of the usage
+	// with them, and uploads, the direct uploads by their id until they expire; changes counts
+	// the changes of the committed segments
+	accounting sync.Mutex
+	temps      map[string]int64
+	uploads    map[string]*directUpload
+	changes    struct{ started, finished int64 }
+
+	server  *http.Server
+	backend Backend
 }
 
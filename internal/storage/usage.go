@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// addTemp accounts for the temporary segment uploaded as tmp.
+func (s *Storage) addTemp(tmp string, size int64) {
+	s.accounting.Lock()
+	defer s.accounting.Unlock()
+
+	s.temps[tmp] = size
+	atomic.AddInt64(&s.Temporary, size)
+	atomic.AddInt64(&s.Used, size)
+}
+
+// rollbackTemp forgets the temporary segment tmp of size bytes once it is rolled back.
+func (s *Storage) rollbackTemp(tmp string, size int64) {
+	s.accounting.Lock()
+	defer s.accounting.Unlock()
+
+	atomic.AddInt64(&s.Temporary, -s.temps[tmp])
+	delete(s.temps, tmp)
+	atomic.AddInt64(&s.Used, -size)
+}
+
+// commitTemp accounts for the temporary segment tmp committed over the segment replaced, if any.
+func (s *Storage) commitTemp(tmp string, replaced *SegmentInfo) {
+	s.accounting.Lock()
+	defer s.accounting.Unlock()
+
+	atomic.AddInt64(&s.Temporary, -s.temps[tmp])
+	delete(s.temps, tmp)
+	if replaced != nil {
+		atomic.AddInt64(&s.Used, -replaced.Size)
+	} else {
+		atomic.AddInt64(&s.Segments, 1)
+	}
+}
+
+// deleteSegment accounts for a deleted segment of size bytes.
+func (s *Storage) deleteSegment(size int64) {
+	s.accounting.Lock()
+	defer s.accounting.Unlock()
+
+	atomic.AddInt64(&s.Used, -size)
+	atomic.AddInt64(&s.Segments, -1)
+}
+
+// changing marks a change of the committed segments until the returned func is called,
+// so that a reconciliation counting the segments meanwhile is dropped.
+func (s *Storage) changing() func() {
+	atomic.AddInt64(&s.changes.started, 1)
+	return func() {
+		atomic.AddInt64(&s.changes.finished, 1)
+	}
+}
+
+// reconcile counts the committed segments of the backend and corrects the usage of the storage,
+// which the handlers only update by the sizes they see: a crash or a segment committed over
+// another one make them drift. It reports whether the count was not disturbed by a change.
+func (s *Storage) reconcile() (bool, error) {
+	started := atomic.LoadInt64(&s.changes.started)
+	if atomic.LoadInt64(&s.changes.finished) != started {
+		return false, nil
+	}
+
+	var segments, committed int64
+	err := s.backend.List(func(info *SegmentInfo) error {
+		segments++
+		committed += info.Size
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	s.accounting.Lock()
+	defer s.accounting.Unlock()
+
+	if atomic.LoadInt64(&s.changes.started) != started {
+		return false, nil
+	}
+
+	// the temporary segments are not listed, they are counted as uploaded
+	used := committed + atomic.LoadInt64(&s.Temporary)
+	if previous := atomic.SwapInt64(&s.Used, used); previous != used {
+		log.Printf("Storage %s usage corrected from %d to %d bytes", s.Addr, previous, used)
+	}
+	if previous := atomic.SwapInt64(&s.Segments, segments); previous != segments {
+		log.Printf("Storage %s segments corrected from %d to %d", s.Addr, previous, segments)
+	}
+	return true, nil
+}